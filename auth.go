@@ -0,0 +1,214 @@
+package gomvc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserLoader loads users of the app by their ids. It's set via
+// Config.UserLoader and used by CurrentUser.
+type UserLoader interface {
+	// LoadUser returns the user with an id, or nil if it doesn't exist
+	LoadUser(id string) (interface{}, error)
+}
+
+// rememberBackend keeps remember-me tokens, it's Config.RememberBackend or
+// a memory backend
+var rememberBackend SessionBackend
+
+// rememberGrace is how long a remember-me token is still accepted after it
+// has been replaced. Requests sent at the same time carry the same token,
+// they would be logged out if only the first one could use it.
+var rememberGrace = 30 * time.Second
+
+// RequireLogin makes some actions of a controller, or all of them if no
+// actions are given, available to logged in users only. Anonymous users
+// are redirected to Config.LoginPath:
+// gomvc.RequireLogin(&Account{})
+// gomvc.RequireLogin(&Posts{}, "New", "CreatePOST")
+func RequireLogin(controller interface{}, actions ...string) {
+	updateSettings(controller, actions, func(s *actionSettings) { s.requireLogin = true })
+}
+
+// checkLogin redirects anonymous users to the login page if the action
+// requires a login. AJAX requests get a 401 instead.
+func (c *Controller) checkLogin() bool {
+	required := false
+	for _, s := range settingsOf(c.ControllerName, c.ActionName) {
+		required = required || s.requireLogin
+	}
+	if !required || c.UserID() != "" {
+		return true
+	}
+	c.redirectToLogin()
+	return false
+}
+
+// redirectToLogin redirects an anonymous user to the login page, or
+// renders a 401 for AJAX requests
+func (c *Controller) redirectToLogin() {
+	if c.IsAjax() || c.Format == "json" || c.Request.Method != "GET" {
+		c.RenderError(http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	c.Redirect(config.LoginPath + "?next=" + url.QueryEscape(c.Request.URL.RequestURI()))
+}
+
+// UserID returns the id of the logged in user or an empty string. A user
+// with a valid remember-me cookie is logged in before the action runs.
+func (c *Controller) UserID() string {
+	return c.Session[ownerKey]
+}
+
+// CurrentUser returns the logged in user loaded via Config.UserLoader, or
+// nil. The user is loaded once per request.
+func (c *Controller) CurrentUser() interface{} {
+	id := c.UserID()
+	if id == "" || config.UserLoader == nil {
+		return nil
+	}
+	if c.currentUserID != id {
+		user, err := config.UserLoader.LoadUser(id)
+		if err != nil {
+			log.Println("gomvc: loading user", id, err)
+			return nil
+		}
+		c.currentUser, c.currentUserID = user, id
+	}
+	return c.currentUser
+}
+
+// Login logs a user in. The session gets a new id to prevent session
+// fixation. If remember is set, the user stays logged in after the session
+// expires, via a remember-me cookie valid for Config.RememberMaxAge.
+func (c *Controller) Login(userID string, remember bool) error {
+	if err := c.RegenerateSession(); err != nil {
+		return err
+	}
+	c.SetSessionOwner(userID)
+	// A new CSRF token is generated for the new session
+	delete(c.Session, csrfKey)
+	c.currentUser, c.currentUserID = nil, ""
+	if remember {
+		return c.issueRememberToken(userID)
+	}
+	return nil
+}
+
+// Logout logs the user out: the session is cleared and gets a new id, and
+// the remember-me token is revoked
+func (c *Controller) Logout() error {
+	if selector, _ := c.rememberCookie(); selector != "" {
+		if err := rememberBackend.Delete(selector); err != nil {
+			return err
+		}
+	}
+	c.DeleteCookie(config.RememberCookie)
+	c.SessionClear()
+	c.currentUser, c.currentUserID = nil, ""
+	return c.RegenerateSession()
+}
+
+// issueRememberToken stores a new remember-me token and sets its cookie.
+// The cookie contains a selector, used for finding the token, and a
+// validator. Only a hash of the validator is stored, so that leaked tokens
+// can't be used. The stored data is the hash, a byte that is set when the
+// token has been replaced, and the user id.
+func (c *Controller) issueRememberToken(userID string) error {
+	selector := newSessionID()
+	validator := securecookie.GenerateRandomKey(32)
+	hash := sha256.Sum256(validator)
+	ttl := time.Duration(config.RememberMaxAge) * time.Second
+	data := append(append(hash[:], 0), userID...)
+	if err := rememberBackend.Save(selector, data, userID, ttl); err != nil {
+		return err
+	}
+	c.SetCookieWith(config.RememberCookie,
+		selector+":"+base64.RawURLEncoding.EncodeToString(validator),
+		CookieOptions{MaxAge: config.RememberMaxAge, HttpOnly: true})
+	return nil
+}
+
+// rememberCookie returns the selector and the validator from the
+// remember-me cookie
+func (c *Controller) rememberCookie() (string, []byte) {
+	parts := strings.SplitN(c.GetCookie(config.RememberCookie), ":", 2)
+	if len(parts) != 2 {
+		return "", nil
+	}
+	validator, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil
+	}
+	return parts[0], validator
+}
+
+// rememberUser logs in an anonymous user with a remember-me cookie. It
+// runs before the action, since the new token's cookie can't be set once
+// the response has started.
+func (c *Controller) rememberUser() {
+	if c.Session[ownerKey] == "" && rememberBackend != nil {
+		c.loginFromCookie()
+	}
+}
+
+// loginFromCookie logs the user in via the remember-me cookie. Tokens are
+// used once: a new one is issued after each login, the old one is accepted
+// for rememberGrace without issuing another one.
+func (c *Controller) loginFromCookie() {
+	selector, validator := c.rememberCookie()
+	if selector == "" {
+		return
+	}
+	data, err := rememberBackend.Load(selector)
+	if err != nil || len(data) <= sha256.Size+1 {
+		c.DeleteCookie(config.RememberCookie)
+		return
+	}
+	hash := sha256.Sum256(validator)
+	if subtle.ConstantTimeCompare(hash[:], data[:sha256.Size]) != 1 {
+		// The token is revoked: someone may be guessing the validator
+		rememberBackend.Delete(selector)
+		c.DeleteCookie(config.RememberCookie)
+		return
+	}
+	replaced := data[sha256.Size] == 1
+	userID := string(data[sha256.Size+1:])
+	if !replaced {
+		data = append([]byte(nil), data...)
+		data[sha256.Size] = 1
+		if err := rememberBackend.Save(selector, data, userID, rememberGrace); err != nil {
+			log.Println("gomvc: remember-me login", err)
+			return
+		}
+	}
+	if err := c.Login(userID, !replaced); err != nil {
+		log.Println("gomvc: remember-me login", err)
+	}
+}
+
+// DeleteRememberTokens revokes all remember-me tokens of a user, e.g. after
+// a password change
+func DeleteRememberTokens(userID string) error {
+	return rememberBackend.DeleteOwner(userID)
+}
+
+// HashPassword hashes a password with bcrypt
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckPassword checks a password against a hash from HashPassword
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
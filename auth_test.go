@@ -0,0 +1,182 @@
+package gomvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// newAuthController creates a controller with an empty session for a
+// request with cookies
+func newAuthController(cookies []*http.Cookie) (*Controller, *httptest.ResponseRecorder) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	return &Controller{
+		Request:         r,
+		Out:             w,
+		Session:         map[string]string{},
+		sessionSnapshot: map[string]string{},
+		gorillaSession:  sessions.NewSession(nil, "s"),
+	}, w
+}
+
+func TestRememberMe(t *testing.T) {
+	config = &Config{IsDev: true, RememberCookie: "remember", RememberMaxAge: 60}
+	backend := NewMemoryBackend(time.Minute)
+	defer backend.Close()
+	rememberBackend = backend
+	// remember logs the user in via cookies like GetHandler does
+	remember := func(cookies []*http.Cookie) (*Controller, []*http.Cookie) {
+		c, w := newAuthController(cookies)
+		c.rememberUser()
+		return c, w.Result().Cookies()
+	}
+	c, w := newAuthController(nil)
+	if err := c.Login("42", true); err != nil || c.UserID() != "42" {
+		t.Fatalf("Login() = %v, UserID() = %q", err, c.UserID())
+	}
+	cookies := w.Result().Cookies()
+	// The cookie logs the user in and is replaced with a new one
+	c, next := remember(cookies)
+	if id := c.UserID(); id != "42" {
+		t.Errorf("UserID() = %q with a remember-me cookie, want 42", id)
+	}
+	if len(next) != 1 || next[0].Value == cookies[0].Value {
+		t.Errorf("the remember-me token wasn't rotated: %v", next)
+	}
+	// Concurrent requests with the replaced token are logged in without
+	// getting another token
+	c, set := remember(cookies)
+	if id := c.UserID(); id != "42" || len(set) != 0 {
+		t.Errorf("UserID() = %q with a replaced token and cookies %v, want 42", id, set)
+	}
+	// Replaced tokens are rejected after the grace period
+	rememberGrace = -time.Second
+	defer func() { rememberGrace = 30 * time.Second }()
+	_, latest := remember(next)
+	c, _ = remember(next)
+	if id := c.UserID(); id != "" {
+		t.Errorf("UserID() = %q with a used token, want none", id)
+	}
+	c, _ = newAuthController(latest)
+	if err := c.Logout(); err != nil || c.UserID() != "" {
+		t.Errorf("Logout() = %v, UserID() = %q", err, c.UserID())
+	}
+	c, _ = remember(latest)
+	if id := c.UserID(); id != "" {
+		t.Errorf("UserID() = %q after Logout(), want none", id)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPassword(hash, "secret") || CheckPassword(hash, "Secret") {
+		t.Errorf("CheckPassword(%q) doesn't match the password", hash)
+	}
+}
+
+type authorizeTest struct{ *Controller }
+
+func (c *authorizeTest) Permissions_() map[string][]string {
+	return map[string][]string{"*": {"posts.view"}, "Delete": {"posts.delete"}}
+}
+
+func TestAuthorize(t *testing.T) {
+	config = &Config{IsDev: true, LoginPath: "/login", Policy: PolicyFunc(
+		func(user interface{}, permission string, object interface{}) bool {
+			return user == "admin" || permission == "posts.view"
+		})}
+	config.UserLoader = userLoaderFunc(func(id string) (interface{}, error) { return id, nil })
+	registerPermissions(&authorizeTest{})
+	defer delete(settings, "authorizeTest")
+	tests := []struct {
+		user, action string
+		code         int
+	}{
+		{"bob", "Index", 200},
+		{"bob", "Delete", 403},
+		{"admin", "Delete", 200},
+		{"", "Index", 302},
+	}
+	for _, test := range tests {
+		c, w := newAuthController(nil)
+		c.ControllerName, c.ActionName = "authorizeTest", test.action
+		if test.user != "" {
+			c.Session[ownerKey] = test.user
+		}
+		if ok := c.authorize(); ok != (test.code == 200) || w.Code != test.code {
+			t.Errorf("%s %s: authorize() = %v with %d, want %d", test.user,
+				test.action, ok, w.Code, test.code)
+		}
+	}
+}
+
+type badPermissions struct{ *Controller }
+
+func (c *badPermissions) Permissions_() []string { return []string{"admin"} }
+
+func TestRegisterPermissions(t *testing.T) {
+	mustPanic(t, "Permissions_ returning a slice", func() {
+		registerPermissions(&badPermissions{})
+	})
+}
+
+type userLoaderFunc func(id string) (interface{}, error)
+
+func (f userLoaderFunc) LoadUser(id string) (interface{}, error) { return f(id) }
+
+type loginTest struct{ *Controller }
+
+// loginTestBefore is set by loginTest.BeforeAction_
+var loginTestBefore bool
+
+func (c *loginTest) BeforeAction_() { loginTestBefore = true }
+func (c *loginTest) Index() string  { return "index" }
+func (c *loginTest) Public() string { return "public" }
+func (c *loginTest) Admin() string  { return "admin" }
+
+func (c *loginTest) Permissions_() map[string][]string {
+	return map[string][]string{"Admin": {"admin"}}
+}
+
+func TestRequireLogin(t *testing.T) {
+	config = &Config{IsDev: true, SessionID: "s", LoginPath: "/login"}
+	sessionStore = sessions.NewCookieStore([]byte("secret"))
+	ActionArgs = map[string]map[string][]string{
+		"loginTest": {"Index": {}, "Public": {}, "Admin": {}},
+	}
+	RequireLogin(&loginTest{}, "Index")
+	defer delete(settings, "loginTest")
+	handler := GetHandler(&loginTest{})
+	tests := []struct {
+		path, location string
+		code           int
+	}{
+		{"/loginTest/Index", "/login?next=%2FloginTest%2FIndex", 302},
+		{"/loginTest/Public", "", 200},
+		{"/loginTest/Admin", "/login?next=%2FloginTest%2FAdmin", 302},
+	}
+	for _, test := range tests {
+		loginTestBefore = false
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.path, nil)
+		handler(w, r)
+		if w.Code != test.code || w.Header().Get("Location") != test.location {
+			t.Errorf("GET %s = %d to %q, want %d to %q", test.path, w.Code,
+				w.Header().Get("Location"), test.code, test.location)
+		}
+		// BeforeAction_ doesn't run for users without access
+		if loginTestBefore != (test.code == 200) {
+			t.Errorf("GET %s: BeforeAction_ ran = %v", test.path, loginTestBefore)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package gomvc
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// templates caches compiled templates by their path:
+// "Home/Index.html" => layout.html + Home/_layout.html + Home/Index.html
+var templates = struct {
+	sync.RWMutex
+	m map[string]*cachedTemplate
+}{m: map[string]*cachedTemplate{}}
+
+// cachedTemplate is a compiled template with all its layouts. The master copy
+// is never executed: html/template can't clone executed templates, and
+// each request needs its own copy to bind request specific functions.
+// Executed copies are reused via the pool.
+type cachedTemplate struct {
+	master *template.Template
+	// root is the template that is executed: the outermost layout or the
+	// action's template if it doesn't declare a layout
+	root string
+	pool sync.Pool
+	// declared are placeholders of the functions from the controller's
+	// CustomTemplateFuncs
+	declared template.FuncMap
+	// files maps source files to their modification times. It's used on
+	// dev to recompile templates after they have been modified.
+	files map[string]time.Time
+}
+
+// pooledTemplate is a copy of a cached template. custom is set if the copy's
+// functions were set by Controller.CustomTemplateFuncs.
+type pooledTemplate struct {
+	*template.Template
+	custom bool
+}
+
+// getTemplate returns a compiled template from the cache. On dev the
+// template is recompiled if any of its files have been modified. Partials
+// rendered on their own are compiled with an empty controller name. funcs
+// are the controller's CustomTemplateFuncs.
+func getTemplate(controller, path string, funcs template.FuncMap) (*cachedTemplate, error) {
+	key := path
+	if controller == "" {
+		key = "partial:" + path
+	}
+	templates.RLock()
+	ct := templates.m[key]
+	templates.RUnlock()
+	if ct != nil && !(config.IsDev && ct.modified()) {
+		return ct, nil
+	}
+	ct, err := compileTemplate(controller, path, funcs)
+	if err != nil {
+		return nil, err
+	}
+	templates.Lock()
+	templates.m[key] = ct
+	templates.Unlock()
+	return ct, nil
+}
+
+// maxLayoutDepth limits nesting of layouts, so that a layout that declares
+// itself doesn't lead to an infinite loop
+const maxLayoutDepth = 10
+
+// errNoTemplate is returned when a template file doesn't exist
+var errNoTemplate = errors.New("template not found")
+
+// compileTemplate parses an action's template and the layouts it's wrapped
+// in. The global layout.html and the controller's _layout.html are parsed as
+// well if they exist, since they define subtemplates used via @t. Partials
+// used by any of these files are parsed last. If controller is empty, path
+// is a partial rendered without layouts. funcs are the controller's
+// CustomTemplateFuncs.
+func compileTemplate(controller, path string, funcs template.FuncMap) (*cachedTemplate, error) {
+	ct := &cachedTemplate{files: map[string]time.Time{}, declared: declareFuncs(funcs)}
+	ct.master = template.New("root").
+		Delims(config.DelimLeft, config.DelimRight).
+		Funcs(defaultFuncs).
+		Funcs(config.TemplateFuncs).
+		Funcs(ct.declared)
+	parsed := map[string]bool{}
+	var partials []string
+	parse := func(v *view, body string) error {
+		parsed[v.name] = true
+		partials = append(partials, v.partials...)
+		_, err := ct.master.New(v.name).Parse(v.text(body))
+		return err
+	}
+	// The view, its layout, the layout's layout etc
+	var chain []*view
+	inChain := map[string]bool{}
+	for name := path; name != ""; name = chain[len(chain)-1].layout {
+		if len(chain) == maxLayoutDepth {
+			return nil, fmt.Errorf("%s: layouts are nested too deep", path)
+		}
+		v, modTime, err := readView(name)
+		ct.files[name] = modTime
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, v)
+		inChain[name] = true
+		if controller == "" {
+			break
+		}
+	}
+	libs := []string{"layout.html"}
+	if controller != "" {
+		libs = append(libs, controller+"/_layout.html")
+	}
+	for _, name := range libs {
+		if inChain[name] {
+			continue
+		}
+		v, modTime, err := readView(name)
+		ct.files[name] = modTime
+		if errors.Is(err, errNoTemplate) {
+			continue
+		}
+		if err == nil {
+			err = parse(v, "")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Parse the outermost layout first: sections defined in views must
+	// replace empty optional sections defined by layouts
+	for i := len(chain) - 1; i >= 0; i-- {
+		body := ""
+		if i > 0 {
+			body = chain[i-1].name
+		}
+		if err := parse(chain[i], body); err != nil {
+			return nil, err
+		}
+	}
+	// Partials can render other partials, so the list grows while it's
+	// being parsed
+	for i := 0; i < len(partials); i++ {
+		name := partials[i]
+		if parsed[name] {
+			continue
+		}
+		v, modTime, err := readView(name)
+		ct.files[name] = modTime
+		if err == nil {
+			err = parse(v, "")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	ct.root = chain[len(chain)-1].name
+	ct.pool.New = func() interface{} {
+		return &pooledTemplate{Template: template.Must(ct.master.Clone())}
+	}
+	return ct, nil
+}
+
+// declareFuncs returns placeholders of functions that are only defined by a
+// controller, so that templates using them can be parsed. A cached template
+// is shared by all requests, so the placeholders are replaced with the
+// request's functions each time the template is executed.
+func declareFuncs(funcs template.FuncMap) template.FuncMap {
+	declared := template.FuncMap{}
+	for name := range funcs {
+		if _, ok := defaultFuncs[name]; ok {
+			continue
+		}
+		if _, ok := config.TemplateFuncs[name]; ok {
+			continue
+		}
+		name := name
+		declared[name] = func(...interface{}) (string, error) {
+			return "", fmt.Errorf("template function %q is not set in CustomTemplateFuncs", name)
+		}
+	}
+	return declared
+}
+
+// modified checks whether any of the template's files have been changed
+// since it was compiled
+func (ct *cachedTemplate) modified() bool {
+	for path, modTime := range ct.files {
+		if !templateModTime(path).Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// execute runs the template with the request's template functions
+func (ct *cachedTemplate) execute(c *Controller, w io.Writer, data interface{}) error {
+	t := ct.pool.Get().(*pooledTemplate)
+	defer ct.pool.Put(t)
+	// Restore the functions overridden by the previous request
+	if t.custom {
+		t.Funcs(defaultFuncs).Funcs(config.TemplateFuncs).Funcs(ct.declared)
+	}
+	t.Funcs(c.requestFuncs())
+	t.custom = len(c.CustomTemplateFuncs) > 0
+	if t.custom {
+		t.Funcs(c.CustomTemplateFuncs)
+	}
+	return t.ExecuteTemplate(w, ct.root, data)
+}
+
+// compileTemplates compiles all action templates on startup, so that the
+// app fails fast on parsing errors instead of showing them to users.
+// Functions from Controller.CustomTemplateFuncs are declared via
+// Config.CustomTemplateFuncNames.
+func compileTemplates() {
+	funcs := template.FuncMap{}
+	for _, name := range config.CustomTemplateFuncNames {
+		funcs[name] = nil
+	}
+	for _, path := range templateNames() {
+		controller := path[:strings.Index(path, "/")]
+		ct, err := compileTemplate(controller, path, funcs)
+		if err != nil {
+			panic(fmt.Sprintf("Template %s: %v", path, err))
+		}
+		templates.m[path] = ct
+	}
+}
+
+// templateNames lists all action templates ("Home/Index.html"). Layouts
+// and files starting with "_" are skipped.
+func templateNames() []string {
+	names := config.AssetNames
+	if config.AssetFunc == nil {
+		names = nil
+		filepath.Walk("v", func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				name, _ := filepath.Rel("v", path)
+				names = append(names, filepath.ToSlash(name))
+			}
+			return nil
+		})
+	}
+	var res []string
+	for _, name := range names {
+		parts := strings.Split(name, "/")
+		if len(parts) == 2 && strings.HasSuffix(name, ".html") &&
+			!strings.HasPrefix(parts[1], "_") {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// templateModTime returns the modification time of a template file, or a
+// zero time if it doesn't exist
+func templateModTime(path string) time.Time {
+	info, err := os.Stat("v/" + path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// readTemplateFile reads a template file from the views directory
+func readTemplateFile(path string) ([]byte, time.Time, error) {
+	modTime := templateModTime(path)
+	b, err := ioutil.ReadFile("v/" + path)
+	if os.IsNotExist(err) {
+		return nil, modTime, fmt.Errorf("%s: %w", path, errNoTemplate)
+	}
+	return b, modTime, err
+}
@@ -0,0 +1,91 @@
+package gomvc
+
+import (
+	"html/template"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// writeViews creates template files in v/ of a temporary working directory
+func writeViews(t *testing.T, files map[string]string) {
+	dir := t.TempDir()
+	for name, text := range files {
+		path := filepath.Join(dir, "v", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestTemplateCache(t *testing.T) {
+	config = &Config{IsDev: true}
+	writeViews(t, map[string]string{"cacheTest/Index.html": "first"})
+	render := func() string {
+		w := httptest.NewRecorder()
+		c := &Controller{Request: httptest.NewRequest("GET", "/", nil), Out: w,
+			ControllerName: "cacheTest", ActionName: "Index",
+			gorillaSession: sessions.NewSession(sessions.NewCookieStore([]byte("secret")), "s")}
+		c.Render(nil)
+		return w.Body.String()
+	}
+	if out := render(); out != "first" {
+		t.Errorf("Render() = %q, want first", out)
+	}
+	first, _ := getTemplate("cacheTest", "cacheTest/Index.html", nil)
+	if second, _ := getTemplate("cacheTest", "cacheTest/Index.html", nil); second != first {
+		t.Error("getTemplate() compiled a cached template again")
+	}
+	// Modified templates are recompiled on dev
+	path := "v/cacheTest/Index.html"
+	ioutil.WriteFile(path, []byte("second"), 0600)
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(path, later, later)
+	if out := render(); out != "second" {
+		t.Errorf("Render() = %q after a change, want second", out)
+	}
+}
+
+func TestCompileTemplates(t *testing.T) {
+	config = &Config{}
+	writeViews(t, map[string]string{
+		"compileTest/Index.html":  "ok",
+		"compileTest/_menu.html":  "{{ if }}",
+		"compileTest/Broken.html": "{{ if }}",
+	})
+	defer func() {
+		err := recover()
+		if err == nil || !strings.Contains(err.(string), "compileTest/Broken.html") {
+			t.Errorf("compileTemplates() panicked with %v, want Broken.html", err)
+		}
+	}()
+	compileTemplates()
+}
+
+func TestCompileTemplatesCustomFuncs(t *testing.T) {
+	config = &Config{CustomTemplateFuncNames: []string{"shout"}}
+	writeViews(t, map[string]string{"customTest/Index.html": `{{ shout "hi" }}`})
+	compileTemplates()
+	w := httptest.NewRecorder()
+	c := &Controller{Out: w, ControllerName: "customTest", ActionName: "Index",
+		CustomTemplateFuncs: template.FuncMap{"shout": strings.ToUpper}}
+	c.Render(nil)
+	if w.Body.String() != "HI" {
+		t.Errorf("Render() = %q, want HI", w.Body.String())
+	}
+	// Functions that are not declared are errors
+	writeViews(t, map[string]string{"customTest/Index.html": `{{ shuot "hi" }}`})
+	mustPanic(t, "compileTemplates() with an unknown function", compileTemplates)
+}
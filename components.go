@@ -0,0 +1,79 @@
+package gomvc
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Component is a reusable piece of UI with its own logic, e.g. a shopping
+// cart widget. Components are registered via RegisterComponent and can be
+// rendered from any view:
+// @component "cart" .User
+type Component interface {
+	// Render returns the model and the partial template that renders it
+	Render(c *Controller, args ...interface{}) (model interface{}, partial string, err error)
+}
+
+// components maps names to registered view components
+var components = map[string]Component{}
+
+// RegisterComponent makes a component available in all views. It should be
+// called before Run.
+func RegisterComponent(name string, comp Component) {
+	components[name] = comp
+}
+
+// renderComponent is the "component" template function
+func (c *Controller) renderComponent(name string, args ...interface{}) (template.HTML, error) {
+	comp, ok := components[name]
+	if !ok {
+		return "", fmt.Errorf("unknown component %q", name)
+	}
+	model, partial, err := comp.Render(c, args...)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = c.executePartial(&buf, partial, model); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// executePartial renders a partial template without layouts:
+// "_userCard" => v/shared/_userCard.html
+func (c *Controller) executePartial(w io.Writer, name string, model interface{}) error {
+	t, err := getTemplate("", partialPath(name), c.CustomTemplateFuncs)
+	if err != nil {
+		return err
+	}
+	return t.execute(c, w, model)
+}
+
+// renderPartial writes a Partial result
+func (c *Controller) renderPartial(res Partial) {
+	if c.stopped {
+		return
+	}
+	c.SetContentType("text/html")
+	t, err := getTemplate("", partialPath(res.Name), c.CustomTemplateFuncs)
+	if err != nil {
+		c.renderError(http.StatusInternalServerError, err)
+		return
+	}
+	// Buffered like views, so that the session is saved after rendering
+	var buf bytes.Buffer
+	if err = t.execute(c, &buf, res.Model); err != nil {
+		log.Println("Template execution error:", err)
+		if config.IsDev {
+			c.Write("Template execution error:", err)
+		}
+		return
+	}
+	c.writeHeader(res.result)
+	buf.WriteTo(c.Out)
+}
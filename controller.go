@@ -1,7 +1,9 @@
 package gomvc
 
 import (
-	"encoding/json"
+	"bytes"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -30,6 +32,10 @@ type Controller struct {
 	// example.com/Account/Unsubscribe?email=1 => "Account/Unsubscribe"
 	Uri string
 
+	// Format is the response format requested via a URL suffix:
+	// example.com/Home/Users.json => "json"
+	Format string
+
 	// ActionName is the name of the running action (method)
 	ActionName string
 
@@ -43,53 +49,63 @@ type Controller struct {
 	// PageTitle defines the title of the HTML page and is set in the action
 	PageTitle string
 
+	// FlashMsg is the text of the first flash message
 	FlashMsg string
 
+	// Locale is the locale of the current request used by T(): "en", "ru"
+	Locale string
+
 	gorillaSession *sessions.Session
-	Session        map[string]string
+	// Session contains session values as strings. Use SessionGet and
+	// SessionSet for values of other types.
+	Session map[string]string
+	// ModelState contains results of validation of the action's form
+	ModelState ModelState
+	// sessionSnapshot contains Session values as they were loaded or last
+	// written, it's used for finding changes
+	sessionSnapshot map[string]string
+	// sessionDirty is set when the session has been modified
+	sessionDirty bool
+	// currentUser is the user loaded by CurrentUser, currentUserID is its id
+	currentUser   interface{}
+	currentUserID string
+	// sessionSaved is set when the session has been saved (or it was
+	// decided that it doesn't need to be)
+	sessionSaved bool
+
+	// Flash messages from the previous request and the ones added in this
+	// request that haven't been shown yet
+	flashes    []FlashMessage
+	newFlashes []FlashMessage
 
 	stopped bool
 }
 
-// View executes a template corresponding to the current controller method
+// Render executes a template corresponding to the current controller method.
+// Compiled templates are cached, on dev they are recompiled after changes.
 func (c *Controller) Render(data interface{}) {
+	c.render(data, result{})
+}
+
+// render executes the action's template and writes it with the status code
+// and the headers of a result. The output is buffered, so that template
+// functions like @csrf_field can still modify the session, which is saved
+// when the response starts.
+func (c *Controller) render(data interface{}, r result) {
 	if c.stopped {
 		return
 	}
-	c.cleanUp()
-	t := template.New("root").
-		Delims(config.DelimLeft, config.DelimRight).
-		Funcs(defaultFuncs).
-		Funcs(c.CustomTemplateFuncs)
-	// A helper function for logging errors
-	showError := func(msg string, err error) {
-		log.Println(msg, err)
-		if config.IsDev {
-			c.Write(msg, err)
-		}
-	}
-	// Parse layout file with all subtemplates first
-	_, err := t.New("layout.html").Parse(readTemplate("layout.html"))
-	if err != nil {
-		showError("Layout template parsing error", err)
-		return
-	}
-	// Parse the local layout template
-	localLayout := c.ControllerName + "/_layout.html"
-	_, err = t.New(localLayout).Parse(readTemplate(localLayout))
-	if err != nil {
-		showError("Local layout template parsing error", err)
-		return
-	}
-	// Now parse the actual template file corresponding to the action
 	path := c.ControllerName + "/" + stripMethodType(c.ActionName) + ".html"
-	_, err = t.New(path).Parse(readTemplate(path))
+	t, err := getTemplate(c.ControllerName, path, c.CustomTemplateFuncs)
 	if err != nil {
-		showError("Template parsing error", err)
+		log.Println("Template parsing error:", err)
+		if config.IsDev {
+			c.Write("Template parsing error: ", err)
+		}
 		return
 	}
-	// Finally, execute it
-	err = t.ExecuteTemplate(c.Out, path, data)
+	var buf bytes.Buffer
+	err = t.execute(c, &buf, data)
 	if err != nil {
 		log.Println("Template execution error:", err)
 		if config.IsDev {
@@ -97,6 +113,8 @@ func (c *Controller) Render(data interface{}) {
 		}
 		return
 	}
+	c.writeHeader(r)
+	buf.WriteTo(c.Out)
 }
 
 // Say prints a string with a newline to http response
@@ -126,24 +144,28 @@ func (c *Controller) GetCookie(key string) string {
 	return res.Value
 }
 
-// SetCookie creates a new cookie valid for 10 days
+// SetCookie creates a new cookie valid for 10 days. Use SetCookieWith for
+// custom attributes.
 func (c *Controller) SetCookie(key string, value string) {
-	http.SetCookie(c.Out, &http.Cookie{
-		Name:    key,
-		Value:   value,
+	c.SetCookieWith(key, value, CookieOptions{
+		MaxAge:  10 * 24 * 3600,
 		Expires: time.Now().Add(10 * 24 * time.Hour),
-		Path:    "/",
 	})
 }
 
+// DeleteCookie deletes a cookie set via SetCookie. Cookies set with a custom
+// path or domain are deleted via SetCookieWith with the same path and domain
+// and a negative MaxAge.
 func (c *Controller) DeleteCookie(key string) {
-	http.SetCookie(c.Out, &http.Cookie{Name: key, Value: "", MaxAge: -1})
+	c.SetCookieWith(key, "", CookieOptions{MaxAge: -1})
 }
 
 func (c *Controller) SetContentType(ct string) {
 	c.Out.Header().Set("Content-Type", ct)
 }
 
+// AllowOrigin sets the Access-Control-Allow-Origin header. Use CORS
+// policies for full CORS support, including preflights.
 func (c *Controller) AllowOrigin(val string) {
 	c.Out.Header().Set("Access-Control-Allow-Origin", val)
 }
@@ -157,15 +179,26 @@ func (c *Controller) IsAjax() bool {
 	return len(h) > 0 && h[0] == "XMLHttpRequest"
 }
 
+// RenderError sends an error response with a specified message and status
+// code. Config.ErrorHandler is used to render it if it's set.
 func (c *Controller) RenderError(msg string, code int) {
-	c.cleanUp()
-	http.Error(c.Out, msg, code)
-	c.stopped = true
+	c.renderError(code, errors.New(msg))
 }
 
-func (c *Controller) Flash(s string) {
-	c.FlashMsg = s
-	c.Session["gomvc_flash"] = s
+// renderError passes an error to Config.ErrorHandler or writes it as plain
+// text and stops the action. Internal errors are only shown on dev.
+func (c *Controller) renderError(code int, err error) {
+	if config.ErrorHandler != nil {
+		config.ErrorHandler(c, code, err)
+	} else {
+		msg := err.Error()
+		if code >= 500 && !config.IsDev {
+			log.Println("gomvc Error:", err)
+			msg = http.StatusText(code)
+		}
+		http.Error(c.Out, msg, code)
+	}
+	c.stopped = true
 }
 
 // Abort stops execution of the current action immediately
@@ -173,25 +206,26 @@ func (c *Controller) Abort() {
 	c.stopped = true
 }
 
-// IP returns client's IP address
-func (c *Controller) IP() string {
-	return c.Request.Header.Get("X-Forwarded-For")
-}
-
-// renderJson returns a marshaled json object with content type 'application/json'.
-// This is usually used for responding to AJAX requests.
-func (c *Controller) renderJson(model interface{}) {
+// renderXml writes a model marshaled to XML with content type
+// 'application/xml'
+func (c *Controller) renderXml(model interface{}, r result) {
 	if c.stopped {
 		return
 	}
-	c.cleanUp()
-	c.SetContentType("application/json")
-	obj, err := json.MarshalIndent(model, "", "\t")
+	// encoding/xml can't encode maps, its error doesn't say what to do
+	if reflect.Indirect(reflect.ValueOf(model)).Kind() == reflect.Map {
+		c.renderError(http.StatusInternalServerError, fmt.Errorf(
+			"gomvc: %T can't be rendered as XML, use a struct instead of a map", model))
+		return
+	}
+	c.SetContentType("application/xml")
+	obj, err := xml.MarshalIndent(model, "", "\t")
 	if err != nil {
-		log.Println(err)
+		c.renderError(http.StatusInternalServerError, err)
 		return
 	}
-	c.Write(string(obj))
+	c.writeHeader(r)
+	c.Write(xml.Header, string(obj))
 }
 
 // Index defines a default action
@@ -211,16 +245,17 @@ func (c *Controller) Index() {
 // InitValues parses the http.Request object and fetches all necessary values
 // for gomvc.Controller
 func (c *Controller) InitValues(w http.ResponseWriter, r *http.Request) {
-	c.Out = w
+	c.Out = &responseWriter{ResponseWriter: w, c: c}
 	c.Request = r
 	values := r.URL.Query()
 	c.Uri = r.URL.Path[1:]
-	c.ActionName = getActionFromUri(c.Uri, c.ControllerName)
+	c.ActionName, c.Format = getActionFromUri(c.Uri, c.ControllerName)
 	// ActionPOST, ActionDELETE etc
 	if r.Method != "GET" {
 		c.ActionName += r.Method
 	}
 	c.PageTitle = ""
+	c.Locale = c.detectLocale()
 	// Generate query string map (Params)
 	c.Params = make(map[string]string)
 	for key, _ := range values {
@@ -237,12 +272,8 @@ func (c *Controller) InitValues(w http.ResponseWriter, r *http.Request) {
 		c.Form[strings.ToLower(key)] = c.Request.PostForm.Get(key)
 	}
 	// Session
-	c.gorillaSession, _ = cookieStore.Get(c.Request, config.SessionID)
-	c.Session = make(map[string]string, 0)
-	for key, val := range c.gorillaSession.Values {
-		c.Session[fmt.Sprintf("%v", key)] = fmt.Sprintf("%v", val)
-	}
-	c.FlashMsg = c.Session["gomvc_flash"]
+	c.loadSession()
+	c.loadFlashes()
 }
 
 func (c *Controller) checkMethodType() bool {
@@ -267,7 +298,7 @@ func runMethod(method reflect.Value, c *Controller) {
 		}
 		return
 	}
-	if !c.checkMethodType() {
+	if !c.checkMethodType() || !c.verifyCSRF() {
 		return
 	}
 	if c.stopped {
@@ -275,6 +306,7 @@ func runMethod(method reflect.Value, c *Controller) {
 	}
 	// Run it via reflect
 	values := make([]reflect.Value, 0)
+	c.ModelState = ModelState{Valid: true}
 	// Loop thru all method args and assign query string parameters to them
 	for i, argName := range ActionArgs[c.ControllerName][c.ActionName] {
 		// Get value from the query string (params)
@@ -287,47 +319,95 @@ func runMethod(method reflect.Value, c *Controller) {
 	}
 	// TODO handle empty values
 	//fmt.Println(c.ControllerName, c.ActionName, values, dump(ActionArgs))
+	// Validate forms, actions check the results via c.ModelState
+	for _, v := range values {
+		if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+			errs, err := validate(v.Interface(), c.Locale)
+			if err != nil {
+				c.renderError(http.StatusInternalServerError, err)
+				return
+			}
+			for _, err := range errs {
+				c.ModelState.add(err)
+			}
+		}
+	}
 	results := method.Call(values)
 	if len(results) > 0 {
-		res := results[0].Interface()
-		switch res.(type) {
-		case JSON:
-			c.renderJson(res.(JSON).Model)
-		case View:
-			switch res.(View).Model.(type) {
-			case RedirectResult:
-			default:
-				c.Render(res.(View).Model)
-			}
-		case string:
-			c.Write(res.(string))
+		c.renderResult(results[0].Interface())
+	}
+}
+
+// renderResult writes an action result (View, JSON, string) to the response
+func (c *Controller) renderResult(res interface{}) {
+	switch res := res.(type) {
+	case JSON:
+		c.renderJson(res)
+	case View:
+		switch res.Model.(type) {
+		case RedirectResult:
+		case emptyResult:
+			c.writeHeader(res.result)
+		case errorResult:
+			c.addHeaders(res.header)
+			c.RenderError(http.StatusText(res.status), res.status)
+		default:
+			c.render(res.Model, res.result)
+		}
+	case Negotiate:
+		c.renderNegotiated(res)
+	case Partial:
+		c.renderPartial(res)
+	case string:
+		c.Write(res)
+	}
+}
+
+// renderNegotiated renders a Negotiate result in the format requested via
+// a URL suffix or the Accept header
+func (c *Controller) renderNegotiated(res Negotiate) {
+	c.Out.Header().Add("Vary", "Accept")
+	format := c.Format
+	if format == "" {
+		format = negotiateFormat(c.Request.Header.Get("Accept"))
+	}
+	switch format {
+	case "json":
+		c.renderJson(JSON{Model: res.Model, result: res.result})
+	case "xml":
+		c.renderXml(res.Model, res.result)
+	default:
+		c.SetContentType("text/html")
+		c.render(res.Model, res.result)
+	}
+}
+
+// addHeaders adds headers set via View.Header() and JSON.Header()
+func (c *Controller) addHeaders(header http.Header) {
+	for key, values := range header {
+		for _, value := range values {
+			c.Out.Header().Add(key, value)
 		}
 	}
 }
 
+// writeHeader sends the status code and headers of an action result
+func (c *Controller) writeHeader(r result) {
+	c.addHeaders(r.header)
+	if r.status == 0 {
+		return
+	}
+	c.Out.WriteHeader(r.status)
+}
+
 // argToValue generates a reflect.Value from an argument type and its
 // corresponding query string or form value
 func (c *Controller) argToValue(stringValue string, argType reflect.Type) reflect.Value {
 	// Handle a struct pointer, this must be a form
 	if argType.Kind() == reflect.Ptr && argType.Elem().Kind() == reflect.Struct {
-		// Dereference the form
-		argType = argType.Elem()
-		// Create a new form object
-		newFormObj := reflect.New(argType)
-		// Set all its fields
-		for i := 0; i < argType.NumField(); i++ {
-			field := newFormObj.Elem().Field(i)
-			fieldName := argType.Field(i).Name // e.g. "Id", "Title"
-			formValue := c.Form[strings.ToLower(fieldName)]
-			switch field.Type().Name() {
-			case "int":
-				field.SetInt(int64(toint(formValue)))
-			case "float64":
-				field.SetFloat(tofloat(formValue))
-			case "string":
-				field.SetString(formValue)
-			}
-		}
+		// Create a new form object and set all its fields
+		newFormObj := reflect.New(argType.Elem())
+		c.bindForm(newFormObj.Elem(), "")
 		return newFormObj
 	} else if argType.Name() == "int" {
 		// Convert to int if this argument is an int, otherwise leave
@@ -336,11 +416,3 @@ func (c *Controller) argToValue(stringValue string, argType reflect.Type) reflec
 	}
 	return reflect.ValueOf(stringValue)
 }
-
-func (c *Controller) cleanUp() {
-	// Save session
-	for key, val := range c.Session {
-		c.gorillaSession.Values[key] = val
-	}
-	c.gorillaSession.Save(c.Request, c.Out)
-}
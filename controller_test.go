@@ -1,6 +1,11 @@
 package gomvc
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
 
 func TestReplaceDashes(t *testing.T) {
 	type testpair struct{ in, out string }
@@ -20,3 +25,191 @@ func TestReplaceDashes(t *testing.T) {
 		}
 	}
 }
+
+func TestGetActionFromUri(t *testing.T) {
+	type testpair struct{ uri, controller, out, format string }
+
+	tests := []testpair{
+		{"", "Home", "Index", ""},
+		{"Register", "Home", "Register", ""},
+		{"Account/Settings", "Account", "Settings", ""},
+		{"Users.json", "Home", "Users", "json"},
+		{"Account/Users.xml", "Account", "Users", "xml"},
+		{"Hello-world.json", "Home", "HelloWorld", "json"},
+		{"Files/Download/data.json", "Files", "Download", ""},
+	}
+
+	for _, test := range tests {
+		res, format := getActionFromUri(test.uri, test.controller)
+		if res != test.out || format != test.format {
+			t.Errorf("getActionFromUri(%q, %q) = %q, %q, want %q, %q",
+				test.uri, test.controller, res, format, test.out, test.format)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	type testpair struct{ in, out string }
+
+	tests := []testpair{
+		{"", "html"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "html"},
+		{"application/json", "json"},
+		{"text/xml;q=0.5, application/json;q=0.9", "json"},
+		{"application/xml", "xml"},
+		{"image/png", "html"},
+	}
+
+	for _, test := range tests {
+		if res := negotiateFormat(test.in); res != test.out {
+			t.Errorf("negotiateFormat(%q) = %q, want %q", test.in, res, test.out)
+		}
+	}
+}
+
+func TestRedirectUrl(t *testing.T) {
+	defer func(old *Config) { config = old }(config)
+	config = &Config{RedirectHosts: []string{"partner.com"}}
+	r, _ := http.NewRequest("GET", "http://example.com/Account/Login", nil)
+	c := &Controller{Request: r}
+	type testpair struct {
+		in, out string
+		ok      bool
+	}
+
+	tests := []testpair{
+		{"Register", "/Register", true},
+		{"httpLogs", "/httpLogs", true},
+		{"/Account/Login?next=1", "/Account/Login?next=1", true},
+		{"http://example.com/Home", "http://example.com/Home", true},
+		{"https://partner.com/", "https://partner.com/", true},
+		{"https://evil.com/", "", false},
+		{"//evil.com", "", false},
+		{"/\\evil.com", "", false},
+		{"HTTPS://evil.com", "", false},
+		{"http:evil.com", "/http:evil.com", true},
+		{"javascript:alert(1)", "/javascript:alert(1)", true},
+	}
+
+	for _, test := range tests {
+		if res, ok := c.redirectUrl(test.in); res != test.out || ok != test.ok {
+			t.Errorf("redirectUrl(%q) = %q, %v, want %q, %v",
+				test.in, res, ok, test.out, test.ok)
+		}
+	}
+	referers := []struct{ referer, out string }{
+		{"http://example.com/Cart?id=1", "/Cart?id=1"},
+		{"http://evil.com/Cart", "/Home"},
+		{"http://example.com//evil.com", "/Home"},
+	}
+	for _, test := range referers {
+		r.Header.Set("Referer", test.referer)
+		w := httptest.NewRecorder()
+		c := &Controller{Request: r, Out: w}
+		c.RedirectBack("/Home")
+		if loc := w.Header().Get("Location"); loc != test.out {
+			t.Errorf("RedirectBack() with %q = %d %q, want %q", test.referer,
+				w.Code, loc, test.out)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	trustedProxies = parseProxies([]string{"10.0.0.0/8", "::1"})
+	defer func() { trustedProxies = nil }()
+	type testpair struct {
+		remote  string
+		headers map[string]string
+		client  hop
+	}
+
+	tests := []testpair{
+		{"1.2.3.4:5000", nil, hop{"1.2.3.4", "http", "example.com"}},
+		// Headers from untrusted clients are ignored
+		{"1.2.3.4:5000", map[string]string{"X-Forwarded-For": "5.6.7.8"},
+			hop{"1.2.3.4", "http", "example.com"}},
+		{"10.0.0.1:5000", nil, hop{"10.0.0.1", "http", "example.com"}},
+		{"10.0.0.1:5000", map[string]string{"X-Real-IP": "5.6.7.8"},
+			hop{"5.6.7.8", "http", "example.com"}},
+		// A spoofed address on the left is skipped
+		{"10.0.0.1:5000", map[string]string{
+			"X-Forwarded-For":   "6.6.6.6, 5.6.7.8, 10.0.0.2",
+			"X-Forwarded-Proto": "https",
+			"X-Forwarded-Host":  "example.org"},
+			hop{"5.6.7.8", "https", "example.org"}},
+		{"[::1]:5000", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			hop{"10.0.0.3", "http", "example.com"}},
+		{"10.0.0.1:5000", map[string]string{"X-Forwarded-For": "garbage, 5.6.7.8"},
+			hop{"5.6.7.8", "http", "example.com"}},
+		{"10.0.0.1:5000", map[string]string{
+			"Forwarded": `for=6.6.6.6, for="[2001:db8::1]:4711";proto=https;host=example.org`,
+			// Forwarded takes precedence
+			"X-Forwarded-For": "5.6.7.8"},
+			hop{"2001:db8::1", "https", "example.org"}},
+		{"10.0.0.1:5000", map[string]string{"Forwarded": "for=unknown"},
+			hop{"10.0.0.1", "http", "example.com"}},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = test.remote
+		for key, value := range test.headers {
+			r.Header.Set(key, value)
+		}
+		c := &Controller{Request: r}
+		if client := c.client(); client != test.client {
+			t.Errorf("client(%s, %v) = %v, want %v", test.remote, test.headers,
+				client, test.client)
+		}
+	}
+}
+
+func TestRenderXmlMap(t *testing.T) {
+	config = &Config{IsDev: true}
+	w := httptest.NewRecorder()
+	c := &Controller{Out: w}
+	c.renderXml(map[string]int{"a": 1}, result{})
+	if w.Code != 500 || !strings.Contains(w.Body.String(), "use a struct") {
+		t.Errorf("renderXml(map) = %d %q, want a 500 explaining the error",
+			w.Code, w.Body.String())
+	}
+}
+
+func TestResults(t *testing.T) {
+	config = &Config{IsDev: true}
+	writeViews(t, map[string]string{"resultTest/Index.html": "page"})
+	tests := []struct {
+		result func(c *Controller) interface{}
+		code   int
+		header string // X-Test
+		body   string
+	}{
+		{func(c *Controller) interface{} { return c.View(nil) }, 200, "", "page"},
+		{func(c *Controller) interface{} { return c.View(nil).Status(202).Header("X-Test", "1") },
+			202, "1", "page"},
+		{func(c *Controller) interface{} { return c.Created(nil).Header("X-Test", "2") },
+			201, "2", "page"},
+		{func(c *Controller) interface{} {
+			return c.JSON(map[string]int{"a": 1}).Status(422).Header("X-Test", "3")
+		}, 422, "3", `{"a":1}`},
+		{func(c *Controller) interface{} { return c.NoContent().Header("X-Test", "4") },
+			204, "4", ""},
+		{func(c *Controller) interface{} { return c.NotFound() }, 404, "", "Not Found\n"},
+		{func(c *Controller) interface{} { return c.Forbidden().Header("X-Test", "5") },
+			403, "5", "Forbidden\n"},
+	}
+	for i, test := range tests {
+		w := httptest.NewRecorder()
+		c := &Controller{Request: httptest.NewRequest("GET", "/", nil), Out: w,
+			ControllerName: "resultTest", ActionName: "Index"}
+		c.renderResult(test.result(c))
+		if w.Code != test.code || w.Header().Get("X-Test") != test.header ||
+			strings.TrimSpace(w.Body.String()) != strings.TrimSpace(test.body) {
+			t.Errorf("%d: got %d %q %q, want %d %q %q", i, w.Code,
+				w.Header().Get("X-Test"), w.Body.String(), test.code, test.header, test.body)
+		}
+		if test.code == 204 && w.Body.Len() != 0 {
+			t.Errorf("%d: NoContent() wrote %q", i, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package gomvc
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// CookieOptions are the attributes of a cookie set via SetCookieWith
+type CookieOptions struct {
+	// MaxAge is the lifetime of the cookie in seconds. The cookie is deleted
+	// when the browser is closed if both MaxAge and Expires are zero.
+	MaxAge  int
+	Expires time.Time
+	// Path is "/" by default
+	Path   string
+	Domain string
+	// HttpOnly hides the cookie from JavaScript
+	HttpOnly bool
+	// Secure cookies are only sent over HTTPS. All cookies are secure on
+	// production unless Config.InsecureCookies is set.
+	Secure bool
+	// SameSite is Config.CookieSameSite by default
+	SameSite http.SameSite
+}
+
+// SetCookieWith sets a cookie with custom attributes:
+// c.SetCookieWith("theme", "dark", gomvc.CookieOptions{MaxAge: 86400 * 365})
+func (c *Controller) SetCookieWith(name, value string, opts CookieOptions) {
+	http.SetCookie(c.Out, newCookie(name, value, opts))
+}
+
+// newCookie creates a cookie with defaults from Config
+func newCookie(name, value string, opts CookieOptions) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		MaxAge:   opts.MaxAge,
+		Expires:  opts.Expires,
+		HttpOnly: opts.HttpOnly,
+		Secure:   opts.Secure || (!config.IsDev && !config.InsecureCookies),
+		SameSite: opts.SameSite,
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = config.CookieSameSite
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	return cookie
+}
+
+// SetSignedCookie sets a cookie that can be read by the client, but can't
+// be modified. The value can be of any type supported by encoding/gob. It's
+// signed with the hash keys from Config.SessionKeys or Config.SessionSecret.
+func (c *Controller) SetSignedCookie(name string, value interface{}, opts CookieOptions) error {
+	return c.setSecureCookie(name, value, opts, false)
+}
+
+// GetSignedCookie decodes a cookie set via SetSignedCookie into dst. An
+// error is returned if the cookie doesn't exist or has been tampered with.
+func (c *Controller) GetSignedCookie(name string, dst interface{}) error {
+	return c.getSecureCookie(name, dst, false)
+}
+
+// SetEncryptedCookie sets a cookie that can't be read or modified by the
+// client. It requires block keys in Config.SessionKeys.
+func (c *Controller) SetEncryptedCookie(name string, value interface{}, opts CookieOptions) error {
+	return c.setSecureCookie(name, value, opts, true)
+}
+
+// GetEncryptedCookie decodes a cookie set via SetEncryptedCookie into dst
+func (c *Controller) GetEncryptedCookie(name string, dst interface{}) error {
+	return c.getSecureCookie(name, dst, true)
+}
+
+// Secure cookies are stored as "lifetime|encoded value". The lifetime is
+// signed together with the name, so that clients can't extend it, and is
+// checked by the codecs when the cookie is decoded.
+func (c *Controller) setSecureCookie(name string, value interface{}, opts CookieOptions, encrypt bool) error {
+	lifetime := strconv.Itoa(cookieLifetime(opts))
+	codecs, err := cookieCodecs(encrypt, lifetime)
+	if err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(name+"|"+lifetime, value, codecs...)
+	if err != nil {
+		return err
+	}
+	c.SetCookieWith(name, lifetime+"|"+encoded, opts)
+	return nil
+}
+
+func (c *Controller) getSecureCookie(name string, dst interface{}, encrypt bool) error {
+	cookie, err := c.Request.Cookie(name)
+	if err != nil {
+		return err
+	}
+	i := strings.IndexByte(cookie.Value, '|')
+	if i == -1 {
+		return errors.New("gomvc: the cookie has no lifetime")
+	}
+	lifetime := cookie.Value[:i]
+	codecs, err := cookieCodecs(encrypt, lifetime)
+	if err != nil {
+		return err
+	}
+	return securecookie.DecodeMulti(name+"|"+lifetime, cookie.Value[i+1:], dst, codecs...)
+}
+
+// cookieLifetime is the number of seconds a secure cookie is accepted for:
+// its MaxAge, the time until it expires or the lifetime of sessions for
+// cookies deleted when the browser is closed
+func cookieLifetime(opts CookieOptions) int {
+	if opts.MaxAge > 0 {
+		return opts.MaxAge
+	}
+	if !opts.Expires.IsZero() {
+		if d := time.Until(opts.Expires); d > 0 {
+			return int(d/time.Second) + 1
+		}
+	}
+	return defaultSessionOptions().MaxAge
+}
+
+// cookieCodecs creates codecs from the session keys. Signed cookies only
+// use hash keys, encrypted cookies only use pairs with block keys. The
+// codecs reject cookies older than lifetime seconds.
+func cookieCodecs(encrypt bool, lifetime string) ([]securecookie.Codec, error) {
+	maxAge, err := strconv.Atoi(lifetime)
+	if err != nil || maxAge < 0 {
+		return nil, errors.New("gomvc: invalid cookie lifetime")
+	}
+	keys := config.SessionKeys
+	if len(keys) == 0 && config.SessionSecret != "" {
+		keys = [][]byte{[]byte(config.SessionSecret)}
+	}
+	var codecs []securecookie.Codec
+	for i := 0; i < len(keys); i += 2 {
+		var block []byte
+		if i+1 < len(keys) {
+			block = keys[i+1]
+		}
+		if encrypt && block == nil {
+			continue
+		}
+		if !encrypt {
+			block = nil
+		}
+		codec := securecookie.New(keys[i], block)
+		codec.MaxAge(maxAge)
+		codecs = append(codecs, codec)
+	}
+	if len(codecs) == 0 && encrypt {
+		return nil, errors.New("gomvc: encrypted cookies require block keys in Config.SessionKeys")
+	}
+	if len(codecs) == 0 {
+		return nil, errors.New("gomvc: signed cookies require Config.SessionSecret or Config.SessionKeys")
+	}
+	return codecs, nil
+}
@@ -0,0 +1,89 @@
+package gomvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecureCookies(t *testing.T) {
+	config = &Config{SessionKeys: [][]byte{
+		[]byte("hash-key-hash-key-hash-key-hash-"), []byte("block-key-block-key-block-key-12"),
+	}}
+	w := httptest.NewRecorder()
+	c := &Controller{Out: w}
+	if err := c.SetSignedCookie("signed", 42, CookieOptions{HttpOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetEncryptedCookie("encrypted", "secret", CookieOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 || !cookies[0].Secure || cookies[0].Path != "/" ||
+		cookies[0].SameSite != http.SameSiteLaxMode || !cookies[0].HttpOnly {
+		t.Fatalf("unexpected cookies %v", cookies)
+	}
+	r, _ := http.NewRequest("GET", "/", nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	// Cookies are bound to their names
+	r.AddCookie(&http.Cookie{Name: "renamed", Value: cookies[0].Value})
+	c.Request = r
+	var n int
+	var s string
+	if err := c.GetSignedCookie("signed", &n); err != nil || n != 42 {
+		t.Errorf("GetSignedCookie() = %v, %v", n, err)
+	}
+	if err := c.GetEncryptedCookie("encrypted", &s); err != nil || s != "secret" {
+		t.Errorf("GetEncryptedCookie() = %q, %v", s, err)
+	}
+	if err := c.GetSignedCookie("renamed", &n); err == nil {
+		t.Error("GetSignedCookie() accepted a renamed cookie")
+	}
+	if err := c.GetEncryptedCookie("signed", &s); err == nil {
+		t.Error("GetEncryptedCookie() accepted a signed cookie")
+	}
+}
+
+func TestSecureCookieLifetime(t *testing.T) {
+	config = &Config{SessionSecret: "secret", SessionMaxAge: 3600}
+	tests := []struct {
+		opts     CookieOptions
+		lifetime int
+	}{
+		{CookieOptions{MaxAge: 60}, 60},
+		{CookieOptions{Expires: time.Now().Add(time.Minute)}, 60},
+		// Browser session cookies live as long as sessions
+		{CookieOptions{}, 3600},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		c := &Controller{Out: w}
+		if err := c.SetSignedCookie("signed", 42, test.opts); err != nil {
+			t.Fatal(err)
+		}
+		cookie := w.Result().Cookies()[0]
+		lifetime, _ := strconv.Atoi(strings.Split(cookie.Value, "|")[0])
+		codecs, _ := cookieCodecs(false, strconv.Itoa(lifetime))
+		// The codecs' limit is unexported, it's read via reflection
+		maxAge := reflect.ValueOf(codecs[0]).Elem().FieldByName("maxAge").Int()
+		if lifetime != test.lifetime || maxAge != int64(test.lifetime) {
+			t.Errorf("%+v: lifetime = %d, codec MaxAge = %d, want %d", test.opts,
+				lifetime, maxAge, test.lifetime)
+		}
+		// Clients can't extend the lifetime
+		cookie.Value = "999999" + cookie.Value[strings.IndexByte(cookie.Value, '|'):]
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.AddCookie(cookie)
+		c.Request = r
+		var n int
+		if err := c.GetSignedCookie("signed", &n); err == nil {
+			t.Errorf("%+v: GetSignedCookie() accepted a cookie with a modified lifetime", test.opts)
+		}
+	}
+}
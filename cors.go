@@ -0,0 +1,190 @@
+package gomvc
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORS is a cross-origin resource sharing policy. It can be set for the
+// whole app via Config.CORS, for paths via CORSForPath and for controllers
+// via CORSForController. Preflight requests are answered automatically.
+type CORS struct {
+	// AllowedOrigins lists origins allowed to make requests:
+	// "https://example.com", "https://*.example.com" or "*" for any origin
+	AllowedOrigins []string
+	// AllowOriginFunc is used for origins not listed in AllowedOrigins
+	AllowOriginFunc func(origin string) bool
+	// AllowedMethods are methods allowed in preflights. Default is GET,
+	// HEAD, POST, PUT and DELETE.
+	AllowedMethods []string
+	// AllowedHeaders are request headers allowed in preflights, "*" allows
+	// all of them. Default is Content-Type, X-Requested-With and
+	// X-CSRF-Token.
+	AllowedHeaders []string
+	// ExposedHeaders are response headers scripts can read
+	ExposedHeaders []string
+	// AllowCredentials allows requests with cookies. It can't be combined
+	// with the "*" origin, which would let any site make requests on behalf
+	// of the app's users.
+	AllowCredentials bool
+	// MaxAge is the number of seconds preflight results can be cached for
+	MaxAge int
+}
+
+var (
+	defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE"}
+	defaultCORSHeaders = []string{"Content-Type", "X-Requested-With", csrfHeader}
+)
+
+// corsPaths are policies set via CORSForPath
+var corsPaths = map[string]*CORS{}
+
+// CORSForPath sets the CORS policy of all URLs starting with a prefix:
+// gomvc.CORSForPath("/api/", &gomvc.CORS{AllowedOrigins: []string{"*"}})
+func CORSForPath(prefix string, policy *CORS) {
+	policy.check()
+	corsPaths[prefix] = policy
+}
+
+// CORSForController sets the CORS policy of a controller. It overrides
+// policies set for paths and the app, a nil policy disables CORS for the
+// controller.
+func CORSForController(controller interface{}, policy *CORS) {
+	policy.check()
+	updateSettings(controller, nil, func(s *actionSettings) {
+		s.cors, s.corsSet = policy, true
+	})
+}
+
+// check panics if the policy allows credentials from any origin
+func (policy *CORS) check() {
+	if policy != nil && policy.AllowCredentials && contains(policy.AllowedOrigins, "*") {
+		panic(`CORS: AllowCredentials can't be used with the "*" origin, ` +
+			"list the allowed origins or use AllowOriginFunc")
+	}
+}
+
+// corsPolicy returns the CORS policy of the request: the controller's, the
+// one with the longest matching path prefix or Config.CORS
+func (c *Controller) corsPolicy() *CORS {
+	if s := settings[c.ControllerName][""]; s != nil && s.corsSet {
+		return s.cors
+	}
+	var policy *CORS
+	longest := -1
+	for prefix, p := range corsPaths {
+		if strings.HasPrefix(c.Request.URL.Path, prefix) && len(prefix) > longest {
+			policy, longest = p, len(prefix)
+		}
+	}
+	if policy != nil {
+		return policy
+	}
+	return config.CORS
+}
+
+// handleCORS adds CORS headers to the response. It returns true if the
+// request was a preflight, which has been answered.
+func (c *Controller) handleCORS() bool {
+	origin := c.Request.Header.Get("Origin")
+	policy := c.corsPolicy()
+	if origin == "" || policy == nil {
+		return false
+	}
+	header := c.Out.Header()
+	header.Add("Vary", "Origin")
+	preflight := c.Request.Method == "OPTIONS" &&
+		c.Request.Header.Get("Access-Control-Request-Method") != ""
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+	}
+	if !policy.allowsOrigin(origin) {
+		if preflight {
+			c.Out.WriteHeader(http.StatusNoContent)
+		}
+		return preflight
+	}
+	if contains(policy.AllowedOrigins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if policy.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if len(policy.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers",
+				strings.Join(policy.ExposedHeaders, ", "))
+		}
+		return false
+	}
+	methods := policy.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	method := c.Request.Header.Get("Access-Control-Request-Method")
+	if !contains(methods, strings.ToUpper(method)) {
+		c.Out.WriteHeader(http.StatusNoContent)
+		return true
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	headers := policy.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	if requested := c.Request.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		if contains(headers, "*") {
+			header.Set("Access-Control-Allow-Headers", requested)
+		} else {
+			header.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		}
+	}
+	if policy.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+	}
+	c.Out.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// allowsOrigin checks whether an origin is allowed by the policy
+func (policy *CORS) allowsOrigin(origin string) bool {
+	for _, pattern := range policy.AllowedOrigins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return policy.AllowOriginFunc != nil && policy.AllowOriginFunc(origin)
+}
+
+// matchOrigin matches an origin against a pattern with an optional
+// wildcard: "https://*.example.com" matches "https://api.example.com"
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" {
+		return true
+	}
+	i := strings.IndexByte(pattern, '*')
+	if i == -1 {
+		return strings.EqualFold(pattern, origin)
+	}
+	origin = strings.ToLower(origin)
+	prefix, suffix := strings.ToLower(pattern[:i]), strings.ToLower(pattern[i+1:])
+	if len(origin) <= len(prefix)+len(suffix) ||
+		!strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	// The wildcard only matches subdomains
+	return !strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:@")
+}
+
+// contains checks whether a list contains a string, ignoring case
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
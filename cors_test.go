@@ -0,0 +1,110 @@
+package gomvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		pattern, origin string
+		ok              bool
+	}{
+		{"*", "https://example.com", true},
+		{"https://example.com", "https://EXAMPLE.com", true},
+		{"https://example.com", "http://example.com", false},
+		{"https://*.example.com", "https://api.example.com", true},
+		{"https://*.example.com", "https://.example.com", false},
+		{"https://*.example.com", "https://evil.com/.example.com", false},
+		{"https://*.example.com", "https://example.com.evil.com", false},
+	}
+	for _, test := range tests {
+		if ok := matchOrigin(test.pattern, test.origin); ok != test.ok {
+			t.Errorf("matchOrigin(%q, %q) = %v, want %v", test.pattern,
+				test.origin, ok, test.ok)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	config = &Config{CORS: &CORS{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}}
+	tests := []struct {
+		origin, method string
+		headers        map[string]string
+	}{
+		{"https://app.example.com", "PUT", map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Allow-Methods":     "GET, HEAD, POST, PUT, DELETE",
+			"Access-Control-Max-Age":           "600",
+		}},
+		{"https://app.example.com", "PATCH", map[string]string{
+			"Access-Control-Allow-Origin":  "https://app.example.com",
+			"Access-Control-Allow-Methods": "",
+		}},
+		{"https://evil.com", "PUT", map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("OPTIONS", "/api/items", nil)
+		r.Header.Set("Origin", test.origin)
+		r.Header.Set("Access-Control-Request-Method", test.method)
+		w := httptest.NewRecorder()
+		c := &Controller{Request: r, Out: w}
+		if !c.handleCORS() || w.Code != http.StatusNoContent {
+			t.Errorf("%s %s: the preflight wasn't answered", test.origin, test.method)
+		}
+		for key, value := range test.headers {
+			if got := w.Header().Get(key); got != value {
+				t.Errorf("%s %s: %s = %q, want %q", test.origin, test.method,
+					key, got, value)
+			}
+		}
+	}
+}
+
+func TestCORSCredentialsAnyOrigin(t *testing.T) {
+	mustPanic(t, "AllowCredentials with the * origin", func() {
+		CORSForPath("/api/", &CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	})
+	if _, ok := corsPaths["/api/"]; ok {
+		t.Error("the rejected policy was registered")
+	}
+}
+
+type corsTest struct{ *Controller }
+
+func TestCORSForController(t *testing.T) {
+	config = &Config{}
+	CORSForPath("/corsTest/", &CORS{AllowedOrigins: []string{"https://path.com"}})
+	defer delete(corsPaths, "/corsTest/")
+	defer delete(settings, "corsTest")
+	tests := []struct {
+		policy *CORS
+		origin string
+	}{
+		{&CORS{AllowedOrigins: []string{"https://controller.com"}}, "https://controller.com"},
+		// A nil policy disables CORS set for paths
+		{nil, ""},
+	}
+	for _, test := range tests {
+		CORSForController(&corsTest{}, test.policy)
+		r, _ := http.NewRequest("GET", "/corsTest/Index", nil)
+		r.Header.Set("Origin", "https://path.com")
+		w := httptest.NewRecorder()
+		c := &Controller{Request: r, Out: w, ControllerName: "corsTest"}
+		c.handleCORS()
+		r.Header.Set("Origin", "https://controller.com")
+		c.handleCORS()
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.origin {
+			t.Errorf("policy %v: Access-Control-Allow-Origin = %q, want %q",
+				test.policy, got, test.origin)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package gomvc
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	// csrfKey is the session key with the CSRF token
+	csrfKey = "gomvc_csrf"
+	// csrfField is the form field with the token rendered by csrf_field
+	csrfField = "csrf_token"
+	// csrfHeader is the header with the token sent by AJAX requests
+	csrfHeader   = "X-CSRF-Token"
+	csrfTokenLen = 32
+)
+
+// errCSRF is passed to the error handler when a request has no valid token
+var errCSRF = errors.New("invalid CSRF token")
+
+// SkipCSRF disables CSRF verification for some actions of a controller, or
+// for all of them if no actions are given. It's meant for endpoints called
+// by other servers, like webhook receivers:
+// gomvc.SkipCSRF(&Webhooks{})
+// gomvc.SkipCSRF(&Payments{}, "NotifyPOST")
+func SkipCSRF(controller interface{}, actions ...string) {
+	updateSettings(controller, actions, func(s *actionSettings) { s.skipCSRF = true })
+}
+
+// CSRFToken returns the CSRF token of the session, generating it if needed.
+// The token is masked with a random value each time, so that it can't be
+// recovered from compressed responses (BREACH).
+func (c *Controller) CSRFToken() string {
+	token := c.csrfSecret()
+	if token == nil {
+		token = securecookie.GenerateRandomKey(csrfTokenLen)
+		c.Session[csrfKey] = base64.RawURLEncoding.EncodeToString(token)
+	}
+	otp := securecookie.GenerateRandomKey(csrfTokenLen)
+	return base64.RawURLEncoding.EncodeToString(append(otp, xorBytes(otp, token)...))
+}
+
+// csrfSecret returns the unmasked token stored in the session or nil
+func (c *Controller) csrfSecret() []byte {
+	token, err := base64.RawURLEncoding.DecodeString(c.Session[csrfKey])
+	if err != nil || len(token) != csrfTokenLen {
+		return nil
+	}
+	return token
+}
+
+// csrfInput renders a hidden input with the CSRF token, used in forms via
+// @csrf_field
+func (c *Controller) csrfInput() template.HTML {
+	return template.HTML(`<input type="hidden" name="` + csrfField +
+		`" value="` + c.CSRFToken() + `">`)
+}
+
+// verifyCSRF checks the CSRF token of requests that can modify data. The
+// token is sent in the csrf_token form field or the X-CSRF-Token header.
+func (c *Controller) verifyCSRF() bool {
+	switch c.Request.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	if config.DisableCSRF {
+		return true
+	}
+	for _, s := range settingsOf(c.ControllerName, c.ActionName) {
+		if s.skipCSRF {
+			return true
+		}
+	}
+	sent := c.Request.Header.Get(csrfHeader)
+	if sent == "" {
+		sent = c.Form[csrfField]
+	}
+	if validCSRFToken(sent, c.csrfSecret()) {
+		return true
+	}
+	c.renderError(http.StatusForbidden, errCSRF)
+	return false
+}
+
+// validCSRFToken checks a masked token against the session's token
+func validCSRFToken(sent string, token []byte) bool {
+	b, err := base64.RawURLEncoding.DecodeString(sent)
+	if err != nil || token == nil || len(b) != 2*csrfTokenLen {
+		return false
+	}
+	unmasked := xorBytes(b[:csrfTokenLen], b[csrfTokenLen:])
+	return subtle.ConstantTimeCompare(unmasked, token) == 1
+}
+
+func xorBytes(a, b []byte) []byte {
+	res := make([]byte, len(a))
+	for i := range a {
+		res[i] = a[i] ^ b[i]
+	}
+	return res
+}
@@ -0,0 +1,106 @@
+package gomvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestCSRFToken(t *testing.T) {
+	c := &Controller{Session: map[string]string{}}
+	token := c.CSRFToken()
+	secret := c.csrfSecret()
+	if secret == nil {
+		t.Fatal("CSRFToken() didn't store the token in the session")
+	}
+	if token == c.CSRFToken() {
+		t.Error("CSRFToken() returned the same masked token twice")
+	}
+	other := (&Controller{Session: map[string]string{}}).CSRFToken()
+	tests := []struct {
+		sent string
+		ok   bool
+	}{
+		{token, true},
+		{c.CSRFToken(), true},
+		{other, false},
+		{c.Session[csrfKey], false},
+		{"", false},
+		{"!!!", false},
+	}
+	for _, test := range tests {
+		if ok := validCSRFToken(test.sent, secret); ok != test.ok {
+			t.Errorf("validCSRFToken(%q) = %v, want %v", test.sent, ok, test.ok)
+		}
+	}
+}
+
+type csrfTest struct{ *Controller }
+
+func (c *csrfTest) Form() View       { return c.View(nil) }
+func (c *csrfTest) SavePOST() string { return "saved" }
+
+func TestCSRFForm(t *testing.T) {
+	config = &Config{IsDev: true, SessionID: "s"}
+	sessionStore = sessions.NewCookieStore([]byte("secret"))
+	ActionArgs = map[string]map[string][]string{
+		"csrfTest": {"Form": {}, "SavePOST": {}},
+	}
+	writeViews(t, map[string]string{
+		"csrfTest/Form.html": "<p>A long form</p>\n<form>@csrf_field</form>",
+	})
+	handler := GetHandler(&csrfTest{})
+	// The token rendered in the form is stored in a new session
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/csrfTest/Form", nil)
+	handler(w, r)
+	cookies := w.Result().Cookies()
+	m := regexp.MustCompile(`value="([^"]+)"`).FindStringSubmatch(w.Body.String())
+	if len(cookies) != 1 || m == nil {
+		t.Fatalf("GET Form = %q with cookies %v, want a form and a session", w.Body, cookies)
+	}
+	tests := []struct {
+		token string
+		code  int
+	}{
+		{m[1], 200},
+		{"", 403},
+	}
+	for _, test := range tests {
+		form := url.Values{csrfField: {test.token}}
+		r, _ = http.NewRequest("POST", "/csrfTest/Save", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookies[0])
+		w = httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != test.code {
+			t.Errorf("POST Save with token %q = %d %q, want %d", test.token,
+				w.Code, w.Body, test.code)
+		}
+	}
+}
+
+func TestSkipCSRF(t *testing.T) {
+	config = &Config{IsDev: true, SessionID: "s"}
+	sessionStore = sessions.NewCookieStore([]byte("secret"))
+	ActionArgs = map[string]map[string][]string{
+		"csrfTest": {"Form": {}, "SavePOST": {}},
+	}
+	handler := GetHandler(&csrfTest{})
+	defer delete(settings, "csrfTest")
+	for _, actions := range [][]string{{"SavePOST"}, nil} {
+		delete(settings, "csrfTest")
+		SkipCSRF(&csrfTest{}, actions...)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/csrfTest/Save", nil)
+		handler(w, r)
+		if w.Code != 200 {
+			t.Errorf("POST Save without a token after SkipCSRF(%v) = %d, want 200", actions, w.Code)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package gomvc
+
+import (
+	"encoding/json"
+	"html/template"
+	"strings"
+)
+
+// flashKey is the session key flash messages are stored under
+const flashKey = "gomvc_flash"
+
+// FlashMessage is a message shown once, usually on the page the user is
+// redirected to after submitting a form
+type FlashMessage struct {
+	// Level is "info", "success" or "error". It's used as a CSS class.
+	Level string
+	Text  string
+	// Data contains extra structured values, e.g. the id of a created item
+	Data map[string]interface{} `json:",omitempty"`
+}
+
+// Flash adds an info flash message. FlashMsg is set for compatibility.
+func (c *Controller) Flash(s string) {
+	c.FlashMsg = s
+	c.AddFlash(FlashMessage{Level: "info", Text: s})
+}
+
+// FlashSuccess adds a success flash message
+func (c *Controller) FlashSuccess(s string) {
+	c.AddFlash(FlashMessage{Level: "success", Text: s})
+}
+
+// FlashError adds an error flash message
+func (c *Controller) FlashError(s string) {
+	c.AddFlash(FlashMessage{Level: "error", Text: s})
+}
+
+// AddFlash adds a flash message. It's shown on the current page if it
+// renders flashes, or on the next one that does.
+func (c *Controller) AddFlash(msg FlashMessage) {
+	c.newFlashes = append(c.newFlashes, msg)
+}
+
+// Flashes returns flash messages from previous requests and the ones added
+// in this request, and consumes them, so that they are never shown again
+func (c *Controller) Flashes() []FlashMessage {
+	res := append(c.flashes, c.newFlashes...)
+	c.flashes, c.newFlashes = nil, nil
+	if _, ok := c.Session[flashKey]; ok {
+		c.SessionDelete(flashKey)
+	}
+	return res
+}
+
+// renderFlashes is the "flashes" template function. It renders and
+// consumes all flash messages:
+// <div class="flash flash-success">Saved</div>
+func (c *Controller) renderFlashes() template.HTML {
+	var b strings.Builder
+	for _, msg := range c.Flashes() {
+		b.WriteString(`<div class="flash flash-` +
+			template.HTMLEscapeString(msg.Level) + `">` +
+			template.HTMLEscapeString(msg.Text) + "</div>")
+	}
+	return template.HTML(b.String())
+}
+
+// loadFlashes fetches flash messages added by previous requests. They stay
+// in the session until Flashes consumes them, so requests that don't show
+// them (AJAX calls, redirects) don't lose them.
+func (c *Controller) loadFlashes() {
+	s, ok := c.Session[flashKey]
+	if !ok {
+		return
+	}
+	if err := json.Unmarshal([]byte(s), &c.flashes); err != nil {
+		// A plain string flash stored by an older version
+		c.flashes = []FlashMessage{{Level: "info", Text: s}}
+	}
+	if len(c.flashes) > 0 {
+		c.FlashMsg = c.flashes[0].Text
+	}
+}
+
+// keepFlashes stores flash messages added in this request that haven't
+// been shown in the session before it's saved, so that they are shown on
+// the next page. Unconsumed messages from previous requests are kept with
+// them.
+func (c *Controller) keepFlashes() {
+	if len(c.newFlashes) == 0 {
+		return
+	}
+	var msgs []FlashMessage
+	msgs = append(msgs, c.flashes...)
+	msgs = append(msgs, c.newFlashes...)
+	b, _ := json.Marshal(msgs)
+	c.Session[flashKey] = string(b)
+}
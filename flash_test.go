@@ -0,0 +1,59 @@
+package gomvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+type flashTest struct{ *Controller }
+
+func (c *flashTest) Save() View   { c.FlashSuccess("Saved"); return c.Redirect("Middle") }
+func (c *flashTest) Middle() View { return c.Redirect("Show") }
+func (c *flashTest) Ajax() string { return "ok" }
+func (c *flashTest) Note() string { c.Flash("Noted"); return "ok" }
+func (c *flashTest) Show() string {
+	res := ""
+	for _, msg := range c.Flashes() {
+		res += msg.Level + ": " + msg.Text
+	}
+	return res
+}
+
+func TestFlashRedirects(t *testing.T) {
+	config = &Config{IsDev: true, SessionID: "s"}
+	sessionStore = sessions.NewCookieStore([]byte("secret"))
+	ActionArgs = map[string]map[string][]string{
+		"flashTest": {"Save": {}, "Middle": {}, "Ajax": {}, "Note": {}, "Show": {}},
+	}
+	handler := GetHandler(&flashTest{})
+	var cookies []*http.Cookie
+	// Messages survive redirects and requests that don't show them, and
+	// are shown once
+	for _, test := range []struct{ path, out string }{
+		{"/Save", ""},
+		{"/Middle", ""},
+		{"/Ajax", "ok"},
+		{"/Show", "success: Saved"},
+		{"/Show", ""},
+		{"/Note", "ok"},
+		{"/Ajax", "ok"},
+		{"/Show", "info: Noted"},
+		{"/Show", ""},
+	} {
+		r, _ := http.NewRequest("GET", test.path, nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code == http.StatusOK && w.Body.String() != test.out {
+			t.Errorf("%s = %q, want %q", test.path, w.Body.String(), test.out)
+		}
+		if c := w.Result().Cookies(); len(c) > 0 {
+			cookies = c
+		}
+	}
+}
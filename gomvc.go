@@ -2,6 +2,7 @@ package gomvc
 
 import (
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
 	"reflect"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 )
 
@@ -26,7 +28,16 @@ var (
 
 	TimeStamp int64
 
-	cookieStore *sessions.CookieStore
+	// controllerRoutes maps controller names to the paths they are routed
+	// to. It's used for generating URLs: "Account" => "/Account/"
+	controllerRoutes = map[string]string{}
+
+	// routedControllers are the types of controllers passed to Route, their
+	// forms' validation rules are checked on startup
+	routedControllers []reflect.Type
+
+	// sessionStore is Config.SessionStore or a cookie store
+	sessionStore sessions.Store
 
 	config *Config
 )
@@ -37,14 +48,130 @@ type Config struct {
 	// automatically is to parse machine's hostname.
 	IsDev bool
 
-	Port      string
-	AssetFunc func(string) ([]byte, error)
+	Port string
+
+	// AssetFunc returns the contents of an embedded asset (a template) on
+	// production. AssetNames lists all assets.
+	AssetFunc  func(string) ([]byte, error)
+	AssetNames []string
 
 	DelimLeft  string
 	DelimRight string
 
-	SessionID     string
+	// TemplateFuncs defines extra html/template functions available in
+	// all templates
+	TemplateFuncs template.FuncMap
+	// CustomTemplateFuncNames lists the functions controllers set via
+	// CustomTemplateFuncs. Templates are compiled on startup in production,
+	// before these functions are known, so they are declared by name.
+	CustomTemplateFuncNames []string
+
+	// SessionID is the name of the session cookie, "gomvc_session" by
+	// default
+	SessionID string
+	// SessionSecret is the key used for signing session cookies. It must be
+	// at least 32 bytes long on production. SessionKeys is used instead if
+	// it's set.
 	SessionSecret string
+	// SessionKeys are pairs of hash and block keys, as in
+	// sessions.NewCookieStore. Block keys enable encryption and can be nil.
+	// The first pair is used for new cookies, the rest are old keys that
+	// are still accepted, so that keys can be rotated without logging
+	// everybody out.
+	SessionKeys [][]byte
+	// SessionMaxAge is the lifetime of sessions in seconds, 30 days by
+	// default. -1 means that sessions end when the browser is closed.
+	SessionMaxAge int
+	// SessionSameSite is the SameSite attribute of the session cookie, Lax
+	// by default
+	SessionSameSite http.SameSite
+	// SessionDomain is the domain of the session cookie. By default the
+	// cookie is only sent to the host that set it.
+	SessionDomain string
+	// SessionStore keeps sessions. Default is a sessions.CookieStore. Use
+	// NewMemoryStore, NewFileStore or NewServerStore with a custom backend
+	// to keep sessions on the server.
+	SessionStore sessions.Store
+
+	// CookieSameSite is the default SameSite attribute of cookies, Lax by
+	// default
+	CookieSameSite http.SameSite
+	// InsecureCookies disables the Secure attribute of cookies on
+	// production, for apps that are not served over HTTPS
+	InsecureCookies bool
+
+	// ErrorHandler renders error responses: RenderError(), NotFound(),
+	// failed JSON marshaling etc. A plain text message is written if it's
+	// not set.
+	ErrorHandler func(c *Controller, code int, err error)
+
+	// LocalesDir contains message catalogs: locales/en.json,
+	// locales/ru.toml. Default is "locales".
+	LocalesDir string
+	// DefaultLocale is used when the client's locale is not available.
+	// Default is "en".
+	DefaultLocale string
+	// LocaleCookie is the cookie the locale set via SetLocale is stored in.
+	// Default is "gomvc_locale".
+	LocaleCookie string
+	// LocaleURLPrefix enables URLs starting with a locale: /ru/Home/Index
+	LocaleURLPrefix bool
+
+	// DisableCSRF disables verification of CSRF tokens in POST, PUT and
+	// DELETE requests. Use SkipCSRF to disable it for some actions only.
+	DisableCSRF bool
+
+	// TrustedProxies lists addresses and networks of reverse proxies and
+	// load balancers: "10.0.0.0/8", "127.0.0.1". Forwarding headers
+	// (X-Forwarded-For, Forwarded etc) are only used for resolving the
+	// client's IP, scheme and host if they were added by these proxies.
+	TrustedProxies []string
+
+	// CORS is the app's CORS policy. Cross-origin requests are not allowed
+	// if it's nil, unless a policy is set via CORSForPath or
+	// CORSForController.
+	CORS *CORS
+
+	// UserLoader loads the logged in user returned by CurrentUser
+	UserLoader UserLoader
+	// LoginPath is where RequireLogin redirects anonymous users,
+	// "/Account/Login" by default. The requested URL is passed in "next".
+	LoginPath string
+	// RememberCookie is the name of the remember-me cookie,
+	// "gomvc_remember" by default
+	RememberCookie string
+	// RememberMaxAge is the lifetime of remember-me tokens in seconds, 30
+	// days by default
+	RememberMaxAge int
+	// RememberBackend keeps remember-me tokens. Default is a memory backend,
+	// use NewFileBackend or a custom backend to keep them across restarts.
+	RememberBackend SessionBackend
+
+	// Policy checks permissions declared by controllers via Permissions_
+	// and used in templates via @can
+	Policy Policy
+
+	// RateLimitStore keeps buckets of rate limits set via
+	// RateLimitController and RateLimitPath. Default is a memory store.
+	RateLimitStore RateLimitStore
+
+	// RedirectHosts lists external hosts that actions are allowed to
+	// redirect to. Redirects to any other host are rejected.
+	RedirectHosts []string
+
+	// JSONCompact disables indentation of JSON results to save bandwidth
+	JSONCompact bool
+	// JSONIndent is used for indenting JSON results, "\t" by default
+	JSONIndent string
+	// JSONPCallback is the name of a query string parameter with a JSONP
+	// callback, e.g. "callback". JSONP is disabled if it's empty.
+	JSONPCallback string
+	// JSONNoEscapeHTML disables escaping of <, > and & in JSON strings
+	JSONNoEscapeHTML bool
+	// JSONStreamMin is the minimum length of a slice that is encoded and
+	// sent element by element instead of being marshaled in memory first.
+	// Streaming is disabled if it's 0.
+	JSONStreamMin int
 }
 
 // Run initializes and starts the web server
@@ -55,26 +182,93 @@ func Run(c *Config) {
 	if config.SessionID == "" {
 		config.SessionID = "gomvc_session"
 	}
+	if config.JSONIndent == "" {
+		config.JSONIndent = "\t"
+	}
+	if config.LocalesDir == "" {
+		config.LocalesDir = "locales"
+	}
+	if config.DefaultLocale == "" {
+		config.DefaultLocale = "en"
+	}
+	if config.LocaleCookie == "" {
+		config.LocaleCookie = "gomvc_locale"
+	}
+	if config.LoginPath == "" {
+		config.LoginPath = "/Account/Login"
+	}
+	if config.RememberCookie == "" {
+		config.RememberCookie = "gomvc_remember"
+	}
+	if config.RememberMaxAge == 0 {
+		config.RememberMaxAge = 86400 * 30
+	}
+	rememberBackend = config.RememberBackend
+	if rememberBackend == nil {
+		rememberBackend = NewMemoryBackend(time.Hour)
+	}
+	rateLimitStore = config.RateLimitStore
+	if rateLimitStore == nil {
+		rateLimitStore = NewMemoryRateLimitStore(time.Minute)
+	}
+	trustedProxies = parseProxies(config.TrustedProxies)
+	config.CORS.check()
 	TimeStamp = time.Now().Unix()
 	getActionsFromSourceFiles()
-	cookieStore = sessions.NewCookieStore([]byte(config.SessionSecret))
-	cookieStore.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   86400 * 30,    // Default session lasts 30 days
-		HttpOnly: true,          // Do not allow the cookie to be read from JS
-		Secure:   !config.IsDev, // Use secure store in production only
-	}
-	http.Handle("/", router)
+	for _, typ := range routedControllers {
+		if err := checkFormRules(typ); err != nil {
+			panic(err)
+		}
+	}
+	loadLocales()
+	// Templates are compiled on startup on production, and lazily on dev,
+	// where they are recompiled after each change
+	if !config.IsDev {
+		compileTemplates()
+	}
+	initSessionStore()
+	var handler http.Handler = limitPaths(router)
+	if config.LocaleURLPrefix {
+		handler = localePrefix(handler)
+	}
+	http.Handle("/", useMiddleware(handler))
 	if config.Port != "" {
 		fmt.Println(http.ListenAndServe(":"+config.Port, nil))
 	}
 }
 
+// initSessionStore sets up Config.SessionStore or creates a cookie store
+func initSessionStore() {
+	sessionStore = config.SessionStore
+	switch store := sessionStore.(type) {
+	case nil:
+		cookieStore := sessions.NewCookieStore(sessionKeys()...)
+		cookieStore.Options = defaultSessionOptions()
+		// Codecs reject cookies older than 30 days unless their MaxAge is
+		// set too
+		cookieStore.MaxAge(cookieStore.Options.MaxAge)
+		sessionStore = cookieStore
+	case *ServerStore:
+		if store.Options == nil {
+			store.Options = defaultSessionOptions()
+		}
+		if len(store.Codecs) == 0 {
+			store.Codecs = securecookie.CodecsFromPairs(sessionKeys()...)
+		}
+		for _, codec := range store.Codecs {
+			if sc, ok := codec.(*securecookie.SecureCookie); ok {
+				sc.MaxAge(store.Options.MaxAge)
+			}
+		}
+	}
+}
+
 // GetHandler generates a net/http handler func from a controller type.
 // A new controller instance is created to handle incoming requests.
 // Example:
 // http.HandleFunc("/Account/", gomvc.GetHandler(&AccountController{}))
 func GetHandler(obj interface{}) func(http.ResponseWriter, *http.Request) {
+	registerPermissions(obj)
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Show a general error message on production
 		if !config.IsDev {
@@ -105,6 +299,15 @@ we have been notified about it. Sorry for the inconvenience.`)
 		c := base.Interface().(*Controller)
 		c.ControllerName = typ.Name()
 		c.InitValues(w, r)
+		// Preflight requests are answered without running actions
+		if c.handleCORS() {
+			return
+		}
+		c.rememberUser()
+		// Check access before any of the controller's code runs
+		if !c.checkRateLimits() || !c.checkLogin() || !c.authorize() {
+			return
+		}
 		// Assign the *gomvc.Controller base
 		parentval.Set(base)
 		// Run the 'before action' action if it exists
@@ -121,14 +324,20 @@ we have been notified about it. Sorry for the inconvenience.`)
 		if afterAction.IsValid() {
 			afterAction.Call([]reflect.Value{})
 		}
-		c.cleanUp()
+		// Save the session if the action didn't write anything
+		c.Out.(*responseWriter).start()
 	}
 }
 
 // Route is a helper method that runs http.HandleFunc for a given path and
 // controller
 func Route(path string, controller interface{}) {
+	routedControllers = append(routedControllers, reflect.TypeOf(controller))
 	if strings.Index(path, "{") == -1 {
+		name := reflect.Indirect(reflect.ValueOf(controller)).Type().Name()
+		if _, ok := controllerRoutes[name]; !ok {
+			controllerRoutes[name] = path
+		}
 		// General routes without variables. Ensure Gorilla mux matches
 		// all children of path:
 		// Route("/", ...) will also match "/Register", "/User" etc
@@ -140,6 +349,25 @@ func Route(path string, controller interface{}) {
 	}
 }
 
+// urlFor returns the path of a controller's action:
+// "Account", "Login" => "/Account/Login"
+// "Home", "Index" => "/"
+func urlFor(controller, action string) string {
+	path, ok := controllerRoutes[controller]
+	if !ok {
+		path = "/" + controller + "/"
+	}
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	if action == "" || action == "Index" {
+		return path
+	}
+	return path + action
+}
+
+// ServeStatic serves files from dir under /prefix/. Middleware added via
+// Use and limits set via RateLimitPath apply to them too.
 func ServeStatic(prefix, dir string) {
-	http.Handle("/"+prefix+"/", staticPrefix(prefix, dir))
+	http.Handle("/"+prefix+"/", withMiddleware(limitPaths(staticPrefix(prefix, dir))))
 }
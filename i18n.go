@@ -0,0 +1,355 @@
+package gomvc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// message is a translation. Messages with plural forms have several
+// versions keyed by CLDR plural categories: "one", "few", "many", "other".
+type message struct {
+	text   string
+	plural map[string]string
+}
+
+// catalogs maps locales to their messages:
+// catalogs["ru"]["welcome_msg"] => "Добро пожаловать!"
+var catalogs = map[string]map[string]message{}
+
+// pluralCategories are the keys of plural forms in locale files
+var pluralCategories = map[string]bool{
+	"zero": true, "one": true, "two": true, "few": true, "many": true,
+	"other": true,
+}
+
+// loadLocales loads message catalogs from Config.LocalesDir. Each file
+// contains messages of one locale: locales/en.json, locales/pt-BR.toml.
+// Messages can be nested, nested keys are joined with dots:
+// {"user": {"greeting": "Hello, {name}!"}} => "user.greeting"
+// An object with plural categories as keys defines plural forms:
+// {"apples": {"one": "{count} apple", "other": "{count} apples"}}
+func loadLocales() {
+	files, _ := filepath.Glob(filepath.Join(config.LocalesDir, "*"))
+	for _, file := range files {
+		ext := filepath.Ext(file)
+		if ext != ".json" && ext != ".toml" {
+			continue
+		}
+		b, err := ioutil.ReadFile(file)
+		handle(err)
+		values := map[string]interface{}{}
+		if ext == ".json" {
+			err = json.Unmarshal(b, &values)
+		} else {
+			err = toml.Unmarshal(b, &values)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("Locale file %s: %v", file, err))
+		}
+		locale := strings.TrimSuffix(filepath.Base(file), ext)
+		if catalogs[locale] == nil {
+			catalogs[locale] = map[string]message{}
+		}
+		addMessages(catalogs[locale], "", values)
+	}
+}
+
+// addMessages adds messages from a decoded locale file to a catalog
+func addMessages(catalog map[string]message, prefix string, values map[string]interface{}) {
+	for key, value := range values {
+		switch value := value.(type) {
+		case string:
+			catalog[prefix+key] = message{text: value}
+		case map[string]interface{}:
+			if !isPlural(value) {
+				addMessages(catalog, prefix+key+".", value)
+				continue
+			}
+			msg := message{plural: map[string]string{}}
+			for category, text := range value {
+				msg.plural[category] = fmt.Sprint(text)
+			}
+			msg.text = msg.plural["other"]
+			catalog[prefix+key] = msg
+		default:
+			catalog[prefix+key] = message{text: fmt.Sprint(value)}
+		}
+	}
+}
+
+// isPlural checks whether all keys of an object are plural categories
+func isPlural(values map[string]interface{}) bool {
+	for key := range values {
+		if !pluralCategories[key] {
+			return false
+		}
+	}
+	return len(values) > 0
+}
+
+// T translates a message to the current locale. Arguments are pairs of
+// names and values interpolated into the message, a single map can be used
+// instead. "count" selects a plural form:
+// c.T("user.greeting", "name", user.Name) => "Hello, Bob!"
+// c.T("apples", "count", 5) => "5 apples"
+// If there's no such message, the key is returned.
+func (c *Controller) T(key string, args ...interface{}) string {
+	return translate(c.Locale, key, args...)
+}
+
+// translate translates a message to a locale, falling back to
+// Config.DefaultLocale
+func translate(locale, key string, args ...interface{}) string {
+	msg, ok := catalogs[locale][key]
+	if !ok {
+		msg, ok = catalogs[config.DefaultLocale][key]
+		locale = config.DefaultLocale
+	}
+	if !ok {
+		if config.IsDev {
+			log.Printf("gomvc: missing translation %q (%s)", key, locale)
+		}
+		return key
+	}
+	params := translationParams(args)
+	text := msg.text
+	if count, ok := params["count"]; ok && msg.plural != nil {
+		if form, ok := msg.plural[pluralCategory(locale, count)]; ok {
+			text = form
+		}
+	}
+	return interpolate(text, params)
+}
+
+// translateOr translates a message like translate, but uses a fallback
+// text if there's no such message in the locale or the default locale. The
+// fallback is interpolated with the parameters too.
+func translateOr(locale, key, fallback string, params map[string]interface{}) string {
+	if !hasMessage(locale, key) {
+		return interpolate(fallback, params)
+	}
+	return translate(locale, key, params)
+}
+
+// hasMessage checks whether a message exists in the locale or the default
+// locale
+func hasMessage(locale, key string) bool {
+	if config == nil {
+		return false
+	}
+	if _, ok := catalogs[locale][key]; ok {
+		return true
+	}
+	_, ok := catalogs[config.DefaultLocale][key]
+	return ok
+}
+
+// translationParams converts T arguments to a map
+func translationParams(args []interface{}) map[string]interface{} {
+	if len(args) == 1 {
+		if params, ok := args[0].(map[string]interface{}); ok {
+			return params
+		}
+	}
+	params := make(map[string]interface{}, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		params[fmt.Sprint(args[i])] = args[i+1]
+	}
+	return params
+}
+
+// interpolate replaces {name} placeholders with parameters
+func interpolate(text string, params map[string]interface{}) string {
+	if len(params) == 0 || !strings.Contains(text, "{") {
+		return text
+	}
+	pairs := make([]string, 0, len(params)*2)
+	for name, value := range params {
+		pairs = append(pairs, "{"+name+"}", fmt.Sprint(value))
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// pluralCategory returns the CLDR plural category of a number for integers
+// in a locale's language. Languages that are not listed use English rules.
+func pluralCategory(locale string, count interface{}) string {
+	n := toInt64(count)
+	if n < 0 {
+		n = -n
+	}
+	lang := strings.ToLower(strings.SplitN(strings.Replace(locale, "_", "-", 1), "-", 2)[0])
+	switch lang {
+	case "ja", "zh", "ko", "vi", "th", "id", "ms", "tr":
+		return "other"
+	case "fr", "pt":
+		if n == 0 || n == 1 {
+			return "one"
+		}
+	case "ru", "uk", "be":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	case "pl":
+		switch {
+		case n == 1:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	case "cs", "sk":
+		switch {
+		case n == 1:
+			return "one"
+		case n >= 2 && n <= 4:
+			return "few"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+	}
+	return "other"
+}
+
+// toInt64 converts a number of any type to int64
+func toInt64(i interface{}) int64 {
+	v := reflect.ValueOf(i)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return int64(v.Float())
+	case reflect.String:
+		return int64(toint(v.String()))
+	}
+	return 0
+}
+
+// detectLocale picks the locale of a request: a URL prefix (/ru/Home),
+// the locale cookie, the Accept-Language header or the default locale
+func (c *Controller) detectLocale() string {
+	if locale, ok := c.Request.Context().Value(localeKey).(string); ok {
+		return locale
+	}
+	if locale := findLocale(c.GetCookie(config.LocaleCookie)); locale != "" {
+		return locale
+	}
+	for _, lang := range acceptLanguages(c.Request.Header.Get("Accept-Language")) {
+		if locale := findLocale(lang); locale != "" {
+			return locale
+		}
+	}
+	return config.DefaultLocale
+}
+
+// SetLocale changes the locale of the current request and remembers it in
+// a cookie
+func (c *Controller) SetLocale(locale string) {
+	if locale = findLocale(locale); locale == "" {
+		return
+	}
+	c.Locale = locale
+	c.SetCookie(config.LocaleCookie, locale)
+}
+
+// findLocale returns a loaded locale matching a language tag, or an empty
+// string: "pt-BR" => "pt-BR" or "pt" if only "pt" is loaded
+func findLocale(tag string) string {
+	if tag == "" {
+		return ""
+	}
+	tag = strings.Replace(tag, "_", "-", -1)
+	for locale := range catalogs {
+		if strings.EqualFold(locale, tag) {
+			return locale
+		}
+	}
+	lang := strings.SplitN(tag, "-", 2)[0]
+	for locale := range catalogs {
+		if strings.EqualFold(locale, lang) {
+			return locale
+		}
+	}
+	return ""
+}
+
+// acceptLanguages returns language tags from the Accept-Language header
+// ordered by quality:
+// "en;q=0.5, ru" => ["ru", "en"]
+func acceptLanguages(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q = tofloat(param[2:])
+			}
+		}
+		langs = append(langs, lang{tag, q})
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	res := make([]string, len(langs))
+	for i, l := range langs {
+		res[i] = l.tag
+	}
+	return res
+}
+
+type contextKey int
+
+const (
+	// localeKey stores the locale from the URL prefix in the request context
+	localeKey contextKey = iota
+	// nonceKey stores the CSP nonce generated by SecurityHeaders
+	nonceKey
+)
+
+// localePrefix strips a locale prefix from the URL and passes the locale
+// to controllers via the request context: /ru/Home/Index => /Home/Index
+func localePrefix(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		if _, ok := catalogs[parts[0]]; ok {
+			path := "/"
+			if len(parts) > 1 {
+				path += parts[1]
+			}
+			r2 := r.WithContext(context.WithValue(r.Context(), localeKey, parts[0]))
+			u := *r.URL
+			u.Path = path
+			u.RawPath = ""
+			r2.URL = &u
+			r = r2
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,53 @@
+package gomvc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	config = &Config{DefaultLocale: "en"}
+	catalogs = map[string]map[string]message{"en": {}, "ru": {}}
+	addMessages(catalogs["en"], "", map[string]interface{}{
+		"welcome": "Welcome!",
+		"user":    map[string]interface{}{"greeting": "Hello, {name}!"},
+		"apples":  map[string]interface{}{"one": "{count} apple", "other": "{count} apples"},
+	})
+	addMessages(catalogs["ru"], "", map[string]interface{}{
+		"apples": map[string]interface{}{
+			"one": "{count} яблоко", "few": "{count} яблока", "many": "{count} яблок",
+		},
+	})
+	type testpair struct {
+		locale, key string
+		args        []interface{}
+		out         string
+	}
+
+	tests := []testpair{
+		{"en", "welcome", nil, "Welcome!"},
+		{"en", "user.greeting", []interface{}{"name", "Bob"}, "Hello, Bob!"},
+		{"en", "user.greeting", []interface{}{map[string]interface{}{"name": "Al"}}, "Hello, Al!"},
+		{"en", "apples", []interface{}{"count", 1}, "1 apple"},
+		{"en", "apples", []interface{}{"count", 5}, "5 apples"},
+		{"ru", "apples", []interface{}{"count", 21}, "21 яблоко"},
+		{"ru", "apples", []interface{}{"count", 3}, "3 яблока"},
+		{"ru", "apples", []interface{}{"count", 11}, "11 яблок"},
+		{"ru", "welcome", nil, "Welcome!"},
+		{"en", "missing_key", nil, "missing_key"},
+	}
+
+	for _, test := range tests {
+		if res := translate(test.locale, test.key, test.args...); res != test.out {
+			t.Errorf("translate(%q, %q, %v) = %q, want %q",
+				test.locale, test.key, test.args, res, test.out)
+		}
+	}
+}
+
+func TestAcceptLanguages(t *testing.T) {
+	res := acceptLanguages("en;q=0.5, ru, pt-BR;q=0.8")
+	if want := []string{"ru", "pt-BR", "en"}; !reflect.DeepEqual(res, want) {
+		t.Errorf("acceptLanguages() = %v, want %v", res, want)
+	}
+}
@@ -0,0 +1,116 @@
+package gomvc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+)
+
+// jsonpCallback matches valid JSONP callback names like "cb" or
+// "jQuery1234.handle". Anything else could be used for XSS.
+var jsonpCallback = regexp.MustCompile(`^[a-zA-Z_$][0-9a-zA-Z_$.]*$`)
+
+// renderJson returns a marshaled json object with content type 'application/json'.
+// This is usually used for responding to AJAX requests. The output is
+// configured via Config.JSONCompact, JSONIndent, JSONPCallback etc.
+func (c *Controller) renderJson(res JSON) {
+	if c.stopped {
+		return
+	}
+	callback := c.jsonpCallback()
+	if callback != "" {
+		c.SetContentType("application/javascript")
+	} else {
+		c.SetContentType("application/json")
+	}
+	// Large slices are streamed, everything else is marshaled in memory
+	// first, so that marshaling errors can still be reported with a 500.
+	// Byte slices are encoded as base64 strings, not arrays.
+	val := reflect.ValueOf(res.Model)
+	if config.JSONStreamMin > 0 && val.Kind() == reflect.Slice &&
+		val.Type().Elem().Kind() != reflect.Uint8 && val.Len() >= config.JSONStreamMin {
+		c.writeHeader(res.result)
+		c.writeJsonp(callback, func(w io.Writer) error {
+			return streamJson(w, val)
+		})
+		return
+	}
+	var buf bytes.Buffer
+	if err := newJsonEncoder(&buf).Encode(res.Model); err != nil {
+		c.renderError(http.StatusInternalServerError, err)
+		return
+	}
+	c.writeHeader(res.result)
+	c.writeJsonp(callback, func(w io.Writer) error {
+		_, err := buf.WriteTo(w)
+		return err
+	})
+}
+
+// writeJsonp writes JSON produced by write, wrapped in a JSONP callback call
+// if a callback is set
+func (c *Controller) writeJsonp(callback string, write func(io.Writer) error) {
+	if callback != "" {
+		c.Write("/**/", callback, "(")
+	}
+	if err := write(c.Out); err != nil {
+		log.Println("JSON encoding error:", err)
+		return
+	}
+	if callback != "" {
+		c.Write(");")
+	}
+}
+
+// jsonpCallback returns the JSONP callback name from the query string, or an
+// empty string if JSONP is disabled or the name is invalid
+func (c *Controller) jsonpCallback() string {
+	if config.JSONPCallback == "" {
+		return ""
+	}
+	callback := c.Request.URL.Query().Get(config.JSONPCallback)
+	if !jsonpCallback.MatchString(callback) {
+		return ""
+	}
+	return callback
+}
+
+// newJsonEncoder creates a json.Encoder configured via Config
+func newJsonEncoder(w io.Writer) *json.Encoder {
+	enc := json.NewEncoder(w)
+	if !config.JSONCompact {
+		enc.SetIndent("", config.JSONIndent)
+	}
+	enc.SetEscapeHTML(!config.JSONNoEscapeHTML)
+	return enc
+}
+
+// streamJson encodes a slice element by element, so that the whole slice
+// doesn't have to be marshaled in memory. Errors can't be reported to the
+// client at this point, since the response has already been started.
+func streamJson(w io.Writer, slice reflect.Value) error {
+	if slice.IsNil() {
+		_, err := io.WriteString(w, "null")
+		return err
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	enc := newJsonEncoder(w)
+	for i := 0; i < slice.Len(); i++ {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(slice.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
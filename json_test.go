@@ -0,0 +1,27 @@
+package gomvc
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRenderJsonStream(t *testing.T) {
+	config = &Config{IsDev: true, JSONCompact: true, JSONStreamMin: 2}
+	tests := []struct {
+		model interface{}
+		out   string
+	}{
+		{[]int{1, 2, 3}, "[1\n,2\n,3\n]"},
+		// Byte slices are not streamed
+		{[]byte("abc"), `"YWJj"`},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		c := &Controller{Out: w}
+		c.renderJson(c.JSON(test.model))
+		if out := strings.TrimSpace(w.Body.String()); out != test.out {
+			t.Errorf("renderJson(%v) = %q, want %q", test.model, out, test.out)
+		}
+	}
+}
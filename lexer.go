@@ -0,0 +1,415 @@
+package gomvc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// itemType identifies the type of lexical items of the gomvc template syntax
+type itemType int
+
+const (
+	itemText          itemType = iota // plain text
+	itemComment                       // @* comment *@
+	itemAction                        // native action: {{ .Field }}
+	itemDot                           // @.
+	itemField                         // @Field.Subfield
+	itemVariable                      // @$var.Field
+	itemKeyword                       // @if .Cond, @range .Items, @end
+	itemTemplate                      // @t header
+	itemCall                          // @func "arg" .Field
+	itemTranslation                   // %translation_key
+	itemLayout                        // @layout "shared/_main"
+	itemSection                       // @section scripts
+	itemRenderBody                    // @renderBody
+	itemRenderSection                 // @renderSection "scripts" optional
+	itemPartial                       // @partial "_userCard" .User
+)
+
+// keywords are Go template actions written as "@keyword arguments\n"
+var keywords = map[string]bool{
+	"if": true, "else": true, "end": true, "range": true, "with": true,
+	"template": true, "define": true, "block": true,
+}
+
+// item is a lexical item: a piece of plain text or a template construct
+type item struct {
+	typ  itemType
+	val  string // text, field, variable, keyword, function or template name
+	args string // arguments of a keyword or a function call
+	line int    // line in the source file
+}
+
+// lexer splits a template in gomvc syntax into items. It's a simple scanner
+// that doesn't use regexes, so that @ and % in plain text (emails, CSS) are
+// not touched.
+type lexer struct {
+	name       string // template file name used in errors
+	input      string
+	left       string // action delimiters, "{{" and "}}" by default
+	right      string
+	pos        int // current position
+	start      int // start of the current text item
+	line       int
+	startLine  int // line of the current text item
+	items      []item
+	escapedBuf strings.Builder // text with escaped @@ and %% replaced
+}
+
+// lexTemplate splits a template into items
+func lexTemplate(name, input, left, right string) ([]item, error) {
+	l := &lexer{name: name, input: input, left: left, right: right,
+		line: 1, startLine: 1}
+	for l.pos < len(l.input) {
+		var err error
+		switch {
+		case strings.HasPrefix(l.input[l.pos:], l.left):
+			err = l.lexAction()
+		case l.input[l.pos] == '@':
+			err = l.lexAt()
+		case l.input[l.pos] == '%':
+			l.lexPercent()
+		default:
+			l.next()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	l.flushText()
+	return l.items, nil
+}
+
+// next advances to the next byte of the text, counting lines
+func (l *lexer) next() {
+	if l.input[l.pos] == '\n' {
+		l.line++
+	}
+	l.pos++
+}
+
+// peek returns the byte at pos+n or 0 at the end of the input
+func (l *lexer) peek(n int) byte {
+	if l.pos+n >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+n]
+}
+
+// afterWord checks whether the current position follows a letter or a
+// digit: "user@Example.com", "100%"
+func (l *lexer) afterWord() bool {
+	return l.pos > 0 && isAlphaNumeric(l.input[l.pos-1])
+}
+
+// flushText emits the plain text scanned since the last item
+func (l *lexer) flushText() {
+	l.escapedBuf.WriteString(l.input[l.start:l.pos])
+	if l.escapedBuf.Len() > 0 {
+		l.items = append(l.items,
+			item{typ: itemText, val: l.escapedBuf.String(), line: l.startLine})
+		l.escapedBuf.Reset()
+	}
+	l.start = l.pos
+	l.startLine = l.line
+}
+
+// emit adds an item that ends at the current position
+func (l *lexer) emit(typ itemType, val, args string, line int) {
+	l.items = append(l.items, item{typ: typ, val: val, args: args, line: line})
+	l.start = l.pos
+	l.startLine = l.line
+}
+
+// escaped replaces an escape sequence (@@, %%) with a single character
+func (l *lexer) escaped(c string) {
+	l.escapedBuf.WriteString(l.input[l.start:l.pos])
+	l.escapedBuf.WriteString(c)
+	l.pos += 2
+	l.start = l.pos
+}
+
+func (l *lexer) errorf(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", l.name, line, fmt.Sprintf(format, args...))
+}
+
+// lexAction copies a native action ({{ .Field }}) as is
+func (l *lexer) lexAction() error {
+	l.flushText()
+	line := l.line
+	end := strings.Index(l.input[l.pos+len(l.left):], l.right)
+	if end == -1 {
+		return l.errorf(line, "unclosed action")
+	}
+	action := l.input[l.pos+len(l.left) : l.pos+len(l.left)+end]
+	for l.pos < l.start+len(l.left)+end+len(l.right) {
+		l.next()
+	}
+	l.emit(itemAction, action, "", line)
+	return nil
+}
+
+// lexAt scans a construct starting with @
+func (l *lexer) lexAt() error {
+	c := l.peek(1)
+	switch {
+	case c == '@':
+		l.escaped("@")
+	case l.afterWord():
+		// An email address
+		l.next()
+	case c == '*':
+		return l.lexComment()
+	case c == '.':
+		l.flushText()
+		l.pos += 2
+		l.emit(itemDot, "", "", l.line)
+	case c == '$':
+		l.flushText()
+		l.pos += 2
+		l.emit(itemVariable, l.scanPath(), "", l.line)
+	case isUpper(c):
+		l.flushText()
+		l.pos++
+		l.emit(itemField, l.scanPath(), "", l.line)
+	case isLower(c):
+		l.flushText()
+		l.pos++
+		return l.lexIdentifier()
+	default:
+		l.next()
+	}
+	return nil
+}
+
+// lexComment skips a comment keeping its line breaks, so that lines in
+// errors match the source file
+func (l *lexer) lexComment() error {
+	l.flushText()
+	line := l.line
+	end := strings.Index(l.input[l.pos+2:], "*@")
+	if end == -1 {
+		return l.errorf(line, "unterminated comment")
+	}
+	comment := l.input[l.pos+2 : l.pos+2+end]
+	for l.pos < l.start+2+end+2 {
+		l.next()
+	}
+	l.emit(itemComment, comment, "", line)
+	return nil
+}
+
+// lexIdentifier scans a keyword, a template call or a function call
+func (l *lexer) lexIdentifier() error {
+	line := l.line
+	name := l.scanWord()
+	switch {
+	case keywords[name]:
+		// Arguments take the rest of the line
+		end := strings.IndexByte(l.input[l.pos:], '\n')
+		if end == -1 {
+			end = len(l.input) - l.pos
+		}
+		args := strings.TrimSpace(l.input[l.pos : l.pos+end])
+		l.pos += end
+		l.emit(itemKeyword, name, args, line)
+	case name == "section":
+		end := strings.IndexByte(l.input[l.pos:], '\n')
+		if end == -1 {
+			end = len(l.input) - l.pos
+		}
+		section := strings.Trim(l.input[l.pos:l.pos+end], " \t\r\"")
+		if section == "" {
+			return l.errorf(line, "missing section name")
+		}
+		l.pos += end
+		l.emit(itemSection, section, "", line)
+	case name == "layout" || name == "renderSection":
+		arg, err := l.scanString()
+		if err != nil {
+			return err
+		}
+		typ, optional := itemLayout, ""
+		if name == "renderSection" {
+			typ = itemRenderSection
+			if strings.HasPrefix(l.input[l.pos:], " optional") {
+				l.pos += len(" optional")
+				optional = "optional"
+			}
+		}
+		l.emit(typ, arg, optional, line)
+	case name == "partial":
+		partial, err := l.scanString()
+		if err != nil {
+			return err
+		}
+		model, err := l.scanArgs(1)
+		if err != nil {
+			return err
+		}
+		l.emit(itemPartial, partialPath(partial), model, line)
+	case name == "renderBody":
+		l.emit(itemRenderBody, "", "", line)
+	case name == "t" && l.peek(0) == ' ' && isAlphaNumeric(l.peek(1)):
+		l.pos++
+		l.emit(itemTemplate, l.scanWord(), "", line)
+	default:
+		args, err := l.scanArgs(0)
+		if err != nil {
+			return err
+		}
+		l.emit(itemCall, name, args, line)
+	}
+	return nil
+}
+
+// scanArgs scans function call arguments: strings, numbers, fields and
+// variables separated by spaces. At most max arguments are scanned if max
+// is not 0.
+func (l *lexer) scanArgs(max int) (string, error) {
+	start := l.pos
+	for n := 0; l.peek(0) == ' ' && (max == 0 || n < max); n++ {
+		c := l.peek(1)
+		switch {
+		case c == '"':
+			l.pos += 2
+			for l.peek(0) != '"' {
+				switch l.peek(0) {
+				case 0, '\n':
+					return "", l.errorf(l.line, "unterminated string")
+				case '\\':
+					l.pos++
+				}
+				l.pos++
+			}
+			l.pos++
+		case c == '.' || c == '$':
+			l.pos += 2
+			l.scanPath()
+		case c >= '0' && c <= '9':
+			l.pos++
+			l.scanWord()
+		default:
+			return l.input[start:l.pos], nil
+		}
+	}
+	return l.input[start:l.pos], nil
+}
+
+// scanString scans a single quoted string argument: @layout "shared/_main"
+func (l *lexer) scanString() (string, error) {
+	line := l.line
+	args, err := l.scanArgs(1)
+	if err != nil {
+		return "", err
+	}
+	s, err := strconv.Unquote(strings.TrimSpace(args))
+	if err != nil {
+		return "", l.errorf(line, "expected a quoted string")
+	}
+	return s, nil
+}
+
+// lexPercent scans a translation key: %key
+func (l *lexer) lexPercent() {
+	c := l.peek(1)
+	switch {
+	case c == '%':
+		l.escaped("%")
+	case !l.afterWord() && (isLetter(c) || c == '_'):
+		l.flushText()
+		l.pos++
+		l.emit(itemTranslation, l.scanPath(), "", l.line)
+	default:
+		l.next()
+	}
+}
+
+// scanWord scans letters, digits and underscores
+func (l *lexer) scanWord() string {
+	start := l.pos
+	for l.pos < len(l.input) && isAlphaNumeric(l.input[l.pos]) {
+		l.pos++
+	}
+	return l.input[start:l.pos]
+}
+
+// scanPath scans words separated by dots: "User.Name". A trailing dot is
+// not included, so that "Hello, @Name." works.
+func (l *lexer) scanPath() string {
+	start := l.pos
+	l.scanWord()
+	for l.peek(0) == '.' && isAlphaNumeric(l.peek(1)) {
+		l.pos++
+		l.scanWord()
+	}
+	return l.input[start:l.pos]
+}
+
+func isLower(c byte) bool  { return c >= 'a' && c <= 'z' }
+func isUpper(c byte) bool  { return c >= 'A' && c <= 'Z' }
+func isLetter(c byte) bool { return isLower(c) || isUpper(c) }
+
+func isAlphaNumeric(c byte) bool {
+	return isLetter(c) || (c >= '0' && c <= '9') || c == '_'
+}
+
+// generate converts items to Go's template syntax. body is the name of the
+// template rendered by @renderBody in layouts. Line breaks are preserved, so
+// lines in Go's template errors match the source file.
+func generate(items []item, left, right, body string) string {
+	var b strings.Builder
+	for _, it := range items {
+		switch it.typ {
+		case itemText:
+			b.WriteString(it.val)
+		case itemComment:
+			b.WriteString(strings.Repeat("\n", strings.Count(it.val, "\n")))
+		case itemAction:
+			b.WriteString(left + it.val + right)
+		case itemDot:
+			b.WriteString(left + "." + right)
+		case itemField:
+			b.WriteString(left + "." + it.val + right)
+		case itemVariable:
+			b.WriteString(left + "$" + it.val + right)
+		case itemKeyword:
+			b.WriteString(left + " " + strings.TrimSpace(it.val+" "+it.args) +
+				" " + right)
+		case itemTemplate:
+			b.WriteString(left + `template "` + it.val + `"` + right)
+		case itemCall:
+			b.WriteString(left + " " + it.val + it.args + " " + right)
+		case itemTranslation:
+			b.WriteString(left + ` T "` + it.val + `" ` + right)
+		case itemSection:
+			b.WriteString(left + `define "` + sectionName(it.val) + `"` + right)
+		case itemPartial:
+			model := strings.TrimSpace(it.args)
+			if model == "" {
+				model = "."
+			}
+			b.WriteString(left + `template "` + it.val + `" ` + model + right)
+		case itemRenderBody:
+			if body != "" {
+				b.WriteString(left + `template "` + body + `" .` + right)
+			}
+		case itemRenderSection:
+			// Optional sections are rendered via block, which defines an
+			// empty section if the view doesn't
+			if it.args == "optional" {
+				b.WriteString(left + `block "` + sectionName(it.val) + `" .` +
+					right + left + "end" + right)
+			} else {
+				b.WriteString(left + `template "` + sectionName(it.val) + `" .` + right)
+			}
+		}
+	}
+	return b.String()
+}
+
+// sectionName returns the name of the template defined by a section
+func sectionName(name string) string {
+	return "section:" + name
+}
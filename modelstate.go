@@ -0,0 +1,121 @@
+package gomvc
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ModelState contains results of validation of the forms an action takes.
+// Forms are validated before the action runs, so the action only has to
+// check Valid and render the form again if it's not valid:
+// if !c.ModelState.Valid { return c.View(form) }
+// In templates, @field_error "Email" renders the first error of a field,
+// @field_value "Email" renders the value the user submitted.
+type ModelState struct {
+	Valid  bool
+	Errors ValidationErrors
+}
+
+// AddError adds a custom error, e.g. "the email is already taken", and
+// marks the state as invalid. Use c.T for messages in the user's locale.
+func (m *ModelState) AddError(field, message string) {
+	m.add(ValidationError{Field: field, Message: message})
+}
+
+func (m *ModelState) add(err ValidationError) {
+	if m.Errors == nil {
+		m.Errors = ValidationErrors{}
+	}
+	m.Errors[err.Field] = append(m.Errors[err.Field], err)
+	m.Valid = false
+}
+
+// fieldError returns the first error of a field for templates
+func (m *ModelState) fieldError(field string) string {
+	return m.Errors.First(field)
+}
+
+// hasError checks whether a field has errors, it's used in templates for
+// highlighting fields
+func (m *ModelState) hasError(field string) bool {
+	return len(m.Errors[field]) > 0
+}
+
+// fieldValue returns the submitted value of a form field, so that invalid
+// values are shown as they were typed: "Address.City" => "address.city"
+func (c *Controller) fieldValue(field string) string {
+	return c.Form[strings.ToLower(field)]
+}
+
+// bindForm sets fields of a form struct from the submitted form. Nested
+// structs are set from fields with prefixes: Address.City <= "address.city".
+// Pointers to structs are allocated if the form has any of their fields.
+// Values that can't be converted to the field's type are added to
+// ModelState as errors.
+func (c *Controller) bindForm(form reflect.Value, prefix string) {
+	typ := form.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		field := form.Field(i)
+		path := prefix + f.Name // e.g. "Id", "Address.City"
+		if field.Kind() == reflect.Struct && field.Type() != timeType {
+			c.bindForm(field, path+".")
+			continue
+		}
+		if field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct &&
+			field.Type().Elem() != timeType {
+			if c.hasFormPrefix(strings.ToLower(path) + ".") {
+				if field.IsNil() {
+					field.Set(reflect.New(field.Type().Elem()))
+				}
+				c.bindForm(field.Elem(), path+".")
+			}
+			continue
+		}
+		formValue, ok := c.Form[strings.ToLower(path)]
+		if !ok {
+			continue
+		}
+		s := strings.TrimSpace(formValue)
+		var err error
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(formValue)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var n int64
+			n, err = strconv.ParseInt(s, 10, 64)
+			field.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var n uint64
+			n, err = strconv.ParseUint(s, 10, 64)
+			field.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			var n float64
+			n, err = strconv.ParseFloat(s, 64)
+			field.SetFloat(n)
+		case reflect.Bool:
+			// Checkboxes send "on" by default
+			b, _ := strconv.ParseBool(s)
+			field.SetBool(b || s == "on")
+		}
+		// Empty numbers are left for the Required rule
+		if err != nil && s != "" {
+			c.ModelState.add(newValidationError(c.Locale, path,
+				fieldLabel(f, c.Locale), Number, "", "", ""))
+		}
+	}
+}
+
+// hasFormPrefix checks whether the form has fields starting with a prefix
+func (c *Controller) hasFormPrefix(prefix string) bool {
+	for key := range c.Form {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
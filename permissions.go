@@ -0,0 +1,90 @@
+package gomvc
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+)
+
+// Policy decides what users are allowed to do. It's set via Config.Policy.
+// Permissions can be roles ("admin"), actions ("posts.edit") or anything
+// else the app's policy understands.
+type Policy interface {
+	// Can checks whether a user has a permission. user is nil for anonymous
+	// users, object is the resource the permission applies to or nil:
+	// Can(user, "edit", post)
+	Can(user interface{}, permission string, object interface{}) bool
+}
+
+// PolicyFunc adapts a function to the Policy interface
+type PolicyFunc func(user interface{}, permission string, object interface{}) bool
+
+func (f PolicyFunc) Can(user interface{}, permission string, object interface{}) bool {
+	return f(user, permission, object)
+}
+
+// Can checks whether the current user has a permission, optionally for an
+// object. It's available in templates to hide UI elements:
+// @if can "edit" .Post
+func (c *Controller) Can(permission string, object ...interface{}) bool {
+	if config.Policy == nil {
+		return false
+	}
+	var obj interface{}
+	if len(object) > 0 {
+		obj = object[0]
+	}
+	return config.Policy.Can(c.CurrentUser(), permission, obj)
+}
+
+// registerPermissions stores the permissions declared by a controller's
+// Permissions_ method, "*" applies to all actions:
+// map[string][]string{"*": {"admin"}, "DeletePOST": {"users.delete"}}
+// The method is called once, when the controller's handler is created.
+func registerPermissions(controller interface{}) {
+	typ := reflect.Indirect(reflect.ValueOf(controller)).Type()
+	method := reflect.New(typ).MethodByName("Permissions_")
+	if !method.IsValid() {
+		return
+	}
+	permissions, ok := method.Call(nil)[0].Interface().(map[string][]string)
+	if !ok {
+		panic(typ.Name() + ".Permissions_ must return map[string][]string")
+	}
+	for action, list := range permissions {
+		if action == "*" {
+			action = ""
+		}
+		updateSettings(controller, []string{action}, func(s *actionSettings) {
+			s.permissions = list
+		})
+	}
+}
+
+// authorize checks the permissions required by the action. Anonymous users
+// are redirected to the login page, users without a permission get a 403.
+func (c *Controller) authorize() bool {
+	var required []string
+	for _, s := range settingsOf(c.ControllerName, c.ActionName) {
+		required = append(required, s.permissions...)
+	}
+	if len(required) == 0 {
+		return true
+	}
+	if c.UserID() == "" {
+		c.redirectToLogin()
+		return false
+	}
+	if config.Policy == nil {
+		log.Println("gomvc:", c.ControllerName, "declares permissions, but Config.Policy is not set")
+	}
+	for _, permission := range required {
+		if !c.Can(permission) {
+			c.renderError(http.StatusForbidden, fmt.Errorf("%s: %s is required",
+				http.StatusText(http.StatusForbidden), permission))
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,197 @@
+package gomvc
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies are the networks from Config.TrustedProxies
+var trustedProxies []*net.IPNet
+
+// parseProxies parses Config.TrustedProxies. Single addresses are
+// converted to networks: "10.0.0.1" => "10.0.0.1/32"
+func parseProxies(proxies []string) []*net.IPNet {
+	var res []*net.IPNet
+	for _, s := range proxies {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid trusted proxy %q: %v", s, err))
+		}
+		res = append(res, network)
+	}
+	return res
+}
+
+// isTrustedProxy checks whether an address belongs to a trusted proxy
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hop is a client or a proxy a request has passed through: its address and
+// the scheme and the host it requested
+type hop struct {
+	addr  string
+	proto string
+	host  string
+}
+
+// IP returns the client's IP address. Forwarding headers are only used if
+// the request comes from one of Config.TrustedProxies, otherwise the address
+// of the connection is returned, so that the IP can't be spoofed.
+func (c *Controller) IP() string {
+	return c.client().addr
+}
+
+// Scheme returns the scheme ("http" or "https") requested by the client,
+// resolved via trusted proxies like IP
+func (c *Controller) Scheme() string {
+	return c.client().proto
+}
+
+// Host returns the host requested by the client, resolved via trusted
+// proxies like IP
+func (c *Controller) Host() string {
+	return c.client().host
+}
+
+// client walks the proxies the request has passed through from the right,
+// and stops at the first hop that is not a trusted proxy: that's the
+// client. Forwarded (RFC 7239) is used if it's present, then
+// X-Forwarded-For and X-Real-IP.
+func (c *Controller) client() hop {
+	r := c.Request
+	client := hop{addr: r.RemoteAddr, proto: "http", host: r.Host}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client.addr = host
+	}
+	if r.TLS != nil {
+		client.proto = "https"
+	}
+	if !isTrustedProxy(client.addr) {
+		return client
+	}
+	hops := forwardedHops(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr := hopAddr(hops[i].addr)
+		if addr == "" {
+			// "unknown" or an obfuscated identifier
+			break
+		}
+		client.addr = addr
+		if hops[i].proto != "" {
+			client.proto = strings.ToLower(hops[i].proto)
+		}
+		if hops[i].host != "" {
+			client.host = hops[i].host
+		}
+		if !isTrustedProxy(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedHops parses the forwarding headers added by proxies
+func forwardedHops(header http.Header) []hop {
+	var hops []hop
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		// Forwarded: for=192.0.2.60;proto=https;host=example.com, for=10.0.0.1
+		for _, element := range splitHeader(values) {
+			var h hop
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				value := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+				switch strings.ToLower(strings.TrimSpace(kv[0])) {
+				case "for":
+					h.addr = value
+				case "proto":
+					h.proto = value
+				case "host":
+					h.host = value
+				}
+			}
+			hops = append(hops, h)
+		}
+		return hops
+	}
+	addrs := splitHeader(header.Values("X-Forwarded-For"))
+	if len(addrs) == 0 {
+		addrs = splitHeader(header.Values("X-Real-IP"))
+	}
+	// X-Forwarded-Proto and X-Forwarded-Host are aligned with the addresses
+	// from the right. If there are fewer values, the first one is used for
+	// the remaining hops.
+	protos := splitHeader(header.Values("X-Forwarded-Proto"))
+	hosts := splitHeader(header.Values("X-Forwarded-Host"))
+	for i, addr := range addrs {
+		hops = append(hops, hop{
+			addr:  addr,
+			proto: alignedValue(protos, i, len(addrs)),
+			host:  alignedValue(hosts, i, len(addrs)),
+		})
+	}
+	return hops
+}
+
+// alignedValue returns the value of a header list aligned from the right
+// with a list of n addresses
+func alignedValue(values []string, i, n int) string {
+	if len(values) == 0 {
+		return ""
+	}
+	i -= n - len(values)
+	if i < 0 {
+		i = 0
+	}
+	return values[i]
+}
+
+// splitHeader splits comma separated header values
+func splitHeader(values []string) []string {
+	var res []string
+	for _, value := range values {
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				res = append(res, s)
+			}
+		}
+	}
+	return res
+}
+
+// hopAddr returns the IP address of a hop without a port, or an empty
+// string if it's not an IP address:
+// "192.0.2.60:4711" => "192.0.2.60"
+// "[2001:db8::1]:4711" => "2001:db8::1"
+func hopAddr(s string) string {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -0,0 +1,254 @@
+package gomvc
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit limits the number of requests a client can make. Requests are
+// counted via token buckets: a bucket holds Limit tokens and is refilled
+// over Period, so short bursts are allowed while the average rate is
+// limited.
+type RateLimit struct {
+	Limit  int
+	Period time.Duration
+	// Key identifies clients: ByIP (default), BySession or ByUser
+	Key func(c *Controller) string
+	// Store keeps buckets, Config.RateLimitStore by default
+	Store RateLimitStore
+	// id separates buckets of different limits in a store
+	id string
+}
+
+// RateLimitResult is the state of a bucket after a request
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps token buckets. Implement it to share limits between
+// servers, e.g. in Redis.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of a key
+	Take(key string, limit int, period time.Duration) (RateLimitResult, error)
+}
+
+// ByIP identifies clients by the IP resolved via Config.TrustedProxies
+func ByIP(c *Controller) string {
+	return "ip:" + c.IP()
+}
+
+// BySession identifies clients by the session id. Clients without a
+// session are identified by their IP. Only stores keeping sessions on the
+// server (ServerStore) have ids, with the default CookieStore all clients
+// are identified by their IP.
+func BySession(c *Controller) string {
+	if c.gorillaSession != nil && c.gorillaSession.ID != "" {
+		return "session:" + c.gorillaSession.ID
+	}
+	return ByIP(c)
+}
+
+// ByUser identifies clients by the logged in user. Anonymous clients are
+// identified by their IP.
+func ByUser(c *Controller) string {
+	if id := c.UserID(); id != "" {
+		return "user:" + id
+	}
+	return ByIP(c)
+}
+
+// Limits registered via RateLimitPath
+var (
+	rateLimitPaths = map[string][]*RateLimit{}
+	rateLimitCount int
+	// rateLimitStore is Config.RateLimitStore or a memory store
+	rateLimitStore RateLimitStore
+)
+
+// errTooManyRequests is passed to the error handler when a limit is hit
+var errTooManyRequests = errors.New(http.StatusText(http.StatusTooManyRequests))
+
+// RateLimitController limits requests to some actions of a controller, or
+// to all of them if no actions are given. Each client has one bucket for
+// all the actions:
+// limit := &gomvc.RateLimit{Limit: 5, Period: time.Minute}
+// gomvc.RateLimitController(&Account{}, limit, "LoginPOST", "RegisterPOST")
+func RateLimitController(controller interface{}, limit *RateLimit, actions ...string) {
+	registerRateLimit(limit)
+	updateSettings(controller, actions, func(s *actionSettings) {
+		s.rateLimits = append(s.rateLimits, limit)
+	})
+}
+
+// RateLimitPath limits requests to all URLs starting with a prefix. These
+// limits run as middleware, so they apply to static files and unknown
+// actions too.
+func RateLimitPath(prefix string, limit *RateLimit) {
+	registerRateLimit(limit)
+	rateLimitPaths[prefix] = append(rateLimitPaths[prefix], limit)
+}
+
+func registerRateLimit(limit *RateLimit) {
+	if limit.Limit <= 0 || limit.Period <= 0 {
+		panic("Rate limits must have a positive Limit and Period")
+	}
+	if limit.id == "" {
+		rateLimitCount++
+		limit.id = strconv.Itoa(rateLimitCount)
+	}
+}
+
+// checkRateLimits applies the limits set via RateLimitController to the
+// action
+func (c *Controller) checkRateLimits() bool {
+	var limits []*RateLimit
+	for _, s := range settingsOf(c.ControllerName, c.ActionName) {
+		limits = append(limits, s.rateLimits...)
+	}
+	return c.takeTokens(limits)
+}
+
+// limitPaths is middleware applying the limits set via RateLimitPath
+func limitPaths(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var limits []*RateLimit
+		for prefix, l := range rateLimitPaths {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				limits = append(limits, l...)
+			}
+		}
+		if len(limits) > 0 {
+			// Key functions get a controller with the request's session,
+			// there's no action yet
+			c := &Controller{Request: r, Out: w}
+			if sessionStore != nil {
+				c.loadSession()
+			}
+			if !c.takeTokens(limits) {
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// takeTokens takes tokens from the buckets of limits. RateLimit-* headers
+// describe the most restrictive limit. If any limit is exceeded, a 429 is
+// rendered.
+func (c *Controller) takeTokens(limits []*RateLimit) bool {
+	var worst *RateLimit
+	var worstRes RateLimitResult
+	for _, limit := range limits {
+		key := limit.Key
+		if key == nil {
+			key = ByIP
+		}
+		store := limit.Store
+		if store == nil {
+			store = rateLimitStore
+		}
+		res, err := store.Take(limit.id+":"+key(c), limit.Limit, limit.Period)
+		if err != nil {
+			// Requests are not blocked if the store is unavailable
+			log.Println("gomvc: rate limit store:", err)
+			continue
+		}
+		if worst == nil || !res.Allowed && worstRes.Allowed ||
+			res.Allowed == worstRes.Allowed && res.Remaining < worstRes.Remaining {
+			worst, worstRes = limit, res
+		}
+	}
+	if worst == nil {
+		return true
+	}
+	header := c.Out.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(worst.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(worstRes.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(seconds(worstRes.Reset)))
+	if worstRes.Allowed {
+		return true
+	}
+	header.Set("Retry-After", strconv.Itoa(seconds(worstRes.RetryAfter)))
+	c.renderError(http.StatusTooManyRequests, errTooManyRequests)
+	return false
+}
+
+// seconds rounds a duration up to seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateLimitStore keeps token buckets in memory
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	cleaner *cleaner
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when the bucket is full, it can be removed then
+}
+
+// NewMemoryRateLimitStore creates a memory store. Full buckets are removed
+// every cleanup interval until the store is closed.
+func NewMemoryRateLimitStore(cleanup time.Duration) *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{buckets: map[string]*bucket{}}
+	s.cleaner = startCleaner(cleanup, s.removeFull)
+	return s
+}
+
+// Close stops removing full buckets
+func (s *MemoryRateLimitStore) Close() error {
+	s.cleaner.stop()
+	return nil
+}
+
+func (s *MemoryRateLimitStore) Take(key string, limit int, period time.Duration) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	// Tokens added per nanosecond
+	rate := float64(limit) / float64(period)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit), b.tokens+float64(now.Sub(b.last))*rate)
+	b.last = now
+	res := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - b.tokens) / rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration((float64(limit) - b.tokens) / rate)
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
+
+func (s *MemoryRateLimitStore) removeFull() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, b := range s.buckets {
+		if now.After(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
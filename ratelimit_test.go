@@ -0,0 +1,106 @@
+package gomvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestRateLimit(t *testing.T) {
+	config = &Config{IsDev: true}
+	store := NewMemoryRateLimitStore(time.Minute)
+	defer store.Close()
+	rateLimitStore = store
+	type Account struct{ *Controller }
+	RateLimitController(&Account{}, &RateLimit{Limit: 2, Period: time.Minute}, "LoginPOST")
+	defer delete(settings, "Account")
+	tests := []struct {
+		ip, action       string
+		code             int
+		remaining, retry string
+	}{
+		{"1.1.1.1", "LoginPOST", 200, "1", ""},
+		{"1.1.1.1", "LoginPOST", 200, "0", ""},
+		{"1.1.1.1", "LoginPOST", 429, "0", "30"},
+		{"1.1.1.1", "Index", 200, "", ""},
+		{"2.2.2.2", "LoginPOST", 200, "1", ""},
+	}
+	for i, test := range tests {
+		r, _ := http.NewRequest("POST", "/Account/Login", nil)
+		r.RemoteAddr = test.ip + ":1000"
+		w := httptest.NewRecorder()
+		c := &Controller{Request: r, Out: w, ControllerName: "Account",
+			ActionName: test.action}
+		ok := c.checkRateLimits()
+		if ok != (test.code == 200) || w.Code != test.code ||
+			w.Header().Get("RateLimit-Remaining") != test.remaining ||
+			w.Header().Get("Retry-After") != test.retry {
+			t.Errorf("%d: checkRateLimits() = %v with %d %v", i, ok, w.Code, w.Header())
+		}
+	}
+}
+
+func TestRateLimitPath(t *testing.T) {
+	config = &Config{IsDev: true}
+	store := NewMemoryRateLimitStore(time.Minute)
+	defer store.Close()
+	rateLimitStore = store
+	defer func(store sessions.Store) { sessionStore = store }(sessionStore)
+	sessionStore = nil
+	RateLimitPath("/api/", &RateLimit{Limit: 1, Period: time.Minute})
+	defer delete(rateLimitPaths, "/api/")
+	// Paths without actions are limited too
+	h := limitPaths(http.NotFoundHandler())
+	for _, test := range []struct {
+		path string
+		code int
+	}{
+		{"/api/unknown", 404},
+		{"/api/unknown", 429},
+		{"/other", 404},
+	} {
+		r, _ := http.NewRequest("GET", test.path, nil)
+		r.RemoteAddr = "1.1.1.1:1000"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s = %d, want %d", test.path, w.Code, test.code)
+		}
+	}
+}
+
+type rateLimitTest struct{ *Controller }
+
+// rateLimitTestBefore counts runs of rateLimitTest.BeforeAction_
+var rateLimitTestBefore int
+
+func (c *rateLimitTest) BeforeAction_() { rateLimitTestBefore++ }
+func (c *rateLimitTest) Index() string  { return "index" }
+
+func TestRateLimitBeforeAction(t *testing.T) {
+	config = &Config{IsDev: true, SessionID: "s"}
+	sessionStore = sessions.NewCookieStore([]byte("secret"))
+	store := NewMemoryRateLimitStore(time.Minute)
+	defer store.Close()
+	rateLimitStore = store
+	ActionArgs = map[string]map[string][]string{"rateLimitTest": {"Index": {}}}
+	RateLimitController(&rateLimitTest{}, &RateLimit{Limit: 1, Period: time.Minute})
+	defer delete(settings, "rateLimitTest")
+	handler := GetHandler(&rateLimitTest{})
+	for _, code := range []int{200, 429} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/rateLimitTest/Index", nil)
+		r.RemoteAddr = "1.1.1.1:1000"
+		handler(w, r)
+		if w.Code != code {
+			t.Errorf("GET Index = %d, want %d", w.Code, code)
+		}
+	}
+	// Limited requests don't run BeforeAction_
+	if rateLimitTestBefore != 1 {
+		t.Errorf("BeforeAction_ ran %d times, want 1", rateLimitTestBefore)
+	}
+}
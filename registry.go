@@ -0,0 +1,60 @@
+package gomvc
+
+import "reflect"
+
+// actionSettings are registered for a whole controller or one of its
+// actions via SkipCSRF and similar functions, or declared by the
+// controller's methods
+type actionSettings struct {
+	// skipCSRF is set via SkipCSRF
+	skipCSRF bool
+	// cors is set via CORSForController, a nil policy disables CORS for
+	// the controller if corsSet is true
+	cors    *CORS
+	corsSet bool
+	// requireLogin is set via RequireLogin
+	requireLogin bool
+	// permissions are declared by the controller's Permissions_ method
+	permissions []string
+	// rateLimits are set via RateLimitController
+	rateLimits []*RateLimit
+}
+
+// settings keeps settings of controllers and actions, the "" action
+// stands for the whole controller:
+// settings["Webhooks"][""] => all actions of Webhooks
+// settings["Payments"]["NotifyPOST"] => one action
+var settings = map[string]map[string]*actionSettings{}
+
+// updateSettings updates the settings of some actions of a controller, or
+// of the whole controller if no actions are given
+func updateSettings(controller interface{}, actions []string, update func(s *actionSettings)) {
+	name := reflect.Indirect(reflect.ValueOf(controller)).Type().Name()
+	if settings[name] == nil {
+		settings[name] = map[string]*actionSettings{}
+	}
+	if len(actions) == 0 {
+		actions = []string{""}
+	}
+	for _, action := range actions {
+		if settings[name][action] == nil {
+			settings[name][action] = &actionSettings{}
+		}
+		update(settings[name][action])
+	}
+}
+
+// settingsOf returns the settings of a whole controller followed by the
+// ones of an action, skipping the ones that were never registered
+func settingsOf(controller, action string) []*actionSettings {
+	var res []*actionSettings
+	for _, a := range []string{"", action} {
+		if s := settings[controller][a]; s != nil {
+			res = append(res, s)
+		}
+		if action == "" {
+			break
+		}
+	}
+	return res
+}
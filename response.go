@@ -0,0 +1,84 @@
+package gomvc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// responseWriter saves the session right before the response headers are
+// sent, so that the session cookie is written exactly once no matter how
+// the action writes its response. If the session can't be saved, the
+// response is replaced with an error.
+type responseWriter struct {
+	http.ResponseWriter
+	c *Controller
+	// started is set when the headers are about to be sent
+	started bool
+	// discard is set when the response has been replaced with an error
+	discard bool
+}
+
+// start saves the session before the headers are sent. If it fails, the
+// error is rendered instead of the action's response.
+func (w *responseWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	if err := w.c.saveSession(); err != nil {
+		header := w.Header()
+		for key := range header {
+			delete(header, key)
+		}
+		w.c.stopped = false
+		w.c.renderError(http.StatusInternalServerError,
+			fmt.Errorf("gomvc: saving session: %v", err))
+		w.discard = true
+	}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.discard {
+		return
+	}
+	w.start()
+	if w.discard {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.start()
+	if w.discard {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher for streaming responses
+func (w *responseWriter) Flush() {
+	w.start()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.discard {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker for websocket upgrades. The session
+// can't be saved after the connection is taken over, so it's not saved.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("gomvc: the response writer can't be hijacked")
+	}
+	w.started = true
+	return h.Hijack()
+}
+
+// Unwrap returns the original writer for http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
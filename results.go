@@ -1,43 +1,281 @@
 package gomvc
 
 import (
+	"log"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
+// result holds the status code and the headers of an action result. They
+// are written by runMethod before the body.
+type result struct {
+	status int
+	header http.Header
+}
+
+// withHeader returns a copy of the result with a header added. The header
+// map is copied, since results are passed around by value.
+func (r result) withHeader(key, value string) result {
+	h := make(http.Header, len(r.header)+1)
+	for k, v := range r.header {
+		h[k] = v
+	}
+	h.Add(key, value)
+	r.header = h
+	return r
+}
+
 type JSON struct {
 	Model interface{}
+	result
+}
+
+// Status sets the HTTP status code of the response: c.JSON(m).Status(422)
+func (j JSON) Status(code int) JSON {
+	j.status = code
+	return j
+}
+
+// Header adds a response header: c.JSON(m).Header("X-Total", "10")
+func (j JSON) Header(key, value string) JSON {
+	j.result = j.withHeader(key, value)
+	return j
 }
 
 type View struct {
 	Model interface{}
+	result
+}
+
+// Status sets the HTTP status code of the response: c.View(m).Status(201)
+func (v View) Status(code int) View {
+	v.status = code
+	return v
+}
+
+// Header adds a response header: c.View(m).Header("X-Foo", "1")
+func (v View) Header(key, value string) View {
+	v.result = v.withHeader(key, value)
+	return v
+}
+
+// Negotiate is rendered as HTML, JSON or XML depending on the URL suffix
+// (.json, .xml) or the Accept header
+type Negotiate struct {
+	Model interface{}
+	result
+}
+
+// Status sets the HTTP status code of the response
+func (n Negotiate) Status(code int) Negotiate {
+	n.status = code
+	return n
+}
+
+// Header adds a response header
+func (n Negotiate) Header(key, value string) Negotiate {
+	n.result = n.withHeader(key, value)
+	return n
+}
+
+// Partial renders a partial template without layouts. It's used for
+// refreshing fragments of a page via AJAX.
+type Partial struct {
+	Name  string
+	Model interface{}
+	result
+}
+
+// Status sets the HTTP status code of the response
+func (p Partial) Status(code int) Partial {
+	p.status = code
+	return p
+}
+
+// Header adds a response header
+func (p Partial) Header(key, value string) Partial {
+	p.result = p.withHeader(key, value)
+	return p
 }
 
 type RedirectResult struct{}
 
-func (c *Controller) JSON(model interface{}) JSON { return JSON{model} }
+// emptyResult is a View model for responses without a body (204 No Content)
+type emptyResult struct{}
+
+// errorResult is a View model for error responses rendered via RenderError
+type errorResult struct{}
+
+func (c *Controller) JSON(model interface{}) JSON { return JSON{Model: model} }
 
 func (c *Controller) View(model interface{}) View {
 	c.SetContentType("text/html")
-	return View{model}
+	return View{Model: model}
+}
+
+// Negotiate serves the model as HTML (the action's template), JSON or XML:
+// /Home/Users, /Home/Users.json, /Home/Users.xml
+func (c *Controller) Negotiate(model interface{}) Negotiate {
+	return Negotiate{Model: model}
+}
+
+// Partial renders a partial template from v/shared (or another directory if
+// the name contains one) with a model:
+// c.Partial("_userCard", user) => v/shared/_userCard.html
+func (c *Controller) Partial(name string, model interface{}) Partial {
+	return Partial{Name: name, Model: model}
+}
+
+// Created renders the view with a 201 Created status code
+func (c *Controller) Created(model interface{}) View {
+	return c.View(model).Status(http.StatusCreated)
+}
+
+// NoContent sends an empty response with a 204 No Content status code
+func (c *Controller) NoContent() View {
+	return View{Model: emptyResult{}}.Status(http.StatusNoContent)
+}
+
+// NotFound sends a 404 Not Found error
+func (c *Controller) NotFound() View {
+	return View{Model: errorResult{}}.Status(http.StatusNotFound)
+}
+
+// Forbidden sends a 403 Forbidden error
+func (c *Controller) Forbidden() View {
+	return View{Model: errorResult{}}.Status(http.StatusForbidden)
 }
 
 // Redirect performs an HTTP redirect to another action in the same controller
 func (c *Controller) Redirect(action string) View {
-	c.cleanUp()
-	if !strings.HasPrefix(action, "http") {
-		action = "/" + action
+	return c.redirect(action, http.StatusFound) // 302
+}
+
+// RedirectPermanent performs a 301 Moved Permanently redirect
+func (c *Controller) RedirectPermanent(action string) View {
+	return c.redirect(action, http.StatusMovedPermanently)
+}
+
+// RedirectSeeOther performs a 303 See Other redirect. It's usually used
+// after a POST request, the browser follows it with a GET.
+func (c *Controller) RedirectSeeOther(action string) View {
+	return c.redirect(action, http.StatusSeeOther)
+}
+
+// RedirectPreserveMethod performs a 307 Temporary Redirect. Unlike 302, the
+// browser repeats the request with the same method and body.
+func (c *Controller) RedirectPreserveMethod(action string) View {
+	return c.redirect(action, http.StatusTemporaryRedirect)
+}
+
+// RedirectPermanentPreserveMethod performs a 308 Permanent Redirect, the
+// permanent version of RedirectPreserveMethod
+func (c *Controller) RedirectPermanentPreserveMethod(action string) View {
+	return c.redirect(action, http.StatusPermanentRedirect)
+}
+
+// RedirectWithFlash sets a flash message and redirects to an action
+func (c *Controller) RedirectWithFlash(action, msg string) View {
+	c.Flash(msg)
+	return c.Redirect(action)
+}
+
+// RedirectToAction redirects to an action of any controller registered via
+// Route(). Params are added to the query string:
+// c.RedirectToAction("Account", "Login", map[string]string{"next": "/Cart"})
+// => /Account/Login?next=%2FCart
+func (c *Controller) RedirectToAction(controller, action string,
+	params map[string]string) View {
+	target := urlFor(controller, action)
+	if len(params) > 0 {
+		query := url.Values{}
+		for key, value := range params {
+			query.Set(key, value)
+		}
+		target += "?" + query.Encode()
 	}
-	http.Redirect(c.Out, c.Request, action, 302)
-	return View{RedirectResult{}}
+	return c.Redirect(target)
+}
+
+// RedirectBack redirects to the page the request came from. The Referer
+// header can be forged, so fallback is used unless it points to this host.
+func (c *Controller) RedirectBack(fallback string) View {
+	referer, err := url.Parse(c.Request.Referer())
+	if err != nil || referer.Host == "" || !strings.EqualFold(referer.Host, c.Host()) ||
+		(referer.Scheme != "http" && referer.Scheme != "https") {
+		return c.Redirect(fallback)
+	}
+	// A path like "//evil.com" would point to another host
+	target := referer.RequestURI()
+	if _, ok := c.redirectUrl(target); !ok {
+		return c.Redirect(fallback)
+	}
+	return c.Redirect(target)
+}
+
+// redirect sends a redirect with a specified status code. Redirects to
+// external hosts that are not listed in Config.RedirectHosts are rejected,
+// so that a user supplied action can't lead to an open redirect.
+func (c *Controller) redirect(target string, code int) View {
+	location, ok := c.redirectUrl(target)
+	if !ok {
+		log.Println("gomvc: redirect to", target, "is not allowed")
+		c.RenderError("Redirect target is not allowed", http.StatusBadRequest)
+		return View{Model: RedirectResult{}}
+	}
+	http.Redirect(c.Out, c.Request, location, code)
+	return View{Model: RedirectResult{}}
+}
+
+// redirectUrl converts a redirect target to a URL and checks whether it's
+// allowed:
+// "Register" => "/Register", true
+// "httpLogs" => "/httpLogs", true
+// "/Account/Login" => "/Account/Login", true
+// "//evil.com" => "", false
+// "https://evil.com" => "", false (unless "evil.com" is in RedirectHosts)
+func (c *Controller) redirectUrl(target string) (string, bool) {
+	if !isAbsoluteUrl(target) && !strings.HasPrefix(target, "/") {
+		target = "/" + target
+	}
+	// Browsers treat "//host" and "/\host" as links to another host
+	if strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "", false
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Opaque != "" {
+		return "", false
+	}
+	if u.Scheme == "" && u.Host == "" {
+		return target, true
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+	if strings.EqualFold(u.Host, c.Host()) {
+		return target, true
+	}
+	for _, host := range config.RedirectHosts {
+		if strings.EqualFold(u.Host, host) {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+// isAbsoluteUrl checks whether a redirect target is an http(s) URL rather
+// than an action name
+func isAbsoluteUrl(target string) bool {
+	target = strings.ToLower(target)
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
 }
 
 func (c *Controller) JSONError(errorMsg string) JSON {
-	c.SetContentType("application/json")
-	c.Out.WriteHeader(http.StatusBadRequest) // 400
-	return JSON{struct{ ErrorMsg string }{errorMsg}}
+	return c.JSON(struct{ ErrorMsg string }{errorMsg}).
+		Status(http.StatusBadRequest) // 400
 }
 
 func (c *Controller) JSONRedirect(url string) JSON {
-	return JSON{struct{ RedirectUrl string }{url}}
+	return JSON{Model: struct{ RedirectUrl string }{url}}
 }
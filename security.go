@@ -0,0 +1,177 @@
+package gomvc
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/securecookie"
+)
+
+// middlewares wrap the app's handler, added via Use
+var middlewares []func(http.Handler) http.Handler
+
+// Use adds middleware wrapping all routes, including static files. It must
+// be called before Run. Middleware runs in the order it was added.
+func Use(middleware ...func(http.Handler) http.Handler) {
+	middlewares = append(middlewares, middleware...)
+}
+
+// useMiddleware wraps a handler in the middleware added via Use
+func useMiddleware(h http.Handler) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// withMiddleware wraps a handler registered before Run, like the ones of
+// ServeStatic, in the middleware added via Use. The chain is built on the
+// first request, since Use can be called after the handler is registered.
+func withMiddleware(h http.Handler) http.Handler {
+	var once sync.Once
+	var wrapped http.Handler
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { wrapped = useMiddleware(h) })
+		wrapped.ServeHTTP(w, r)
+	})
+}
+
+// NonceSource is replaced with the request's nonce in CSP sources:
+// NewCSP().Add("script-src", "'self'", gomvc.NonceSource)
+// => "script-src 'self' 'nonce-4Fz...'"
+const NonceSource = "'nonce'"
+
+// CSP builds a Content-Security-Policy header
+type CSP struct {
+	directives []string
+	sources    map[string][]string
+	// ReportOnly sends the policy via Content-Security-Policy-Report-Only,
+	// so that violations are reported but nothing is blocked
+	ReportOnly bool
+}
+
+// NewCSP creates an empty policy
+func NewCSP() *CSP {
+	return &CSP{sources: map[string][]string{}}
+}
+
+// Add adds sources to a directive:
+// NewCSP().Add("default-src", "'self'").Add("img-src", "'self'", "data:")
+func (p *CSP) Add(directive string, sources ...string) *CSP {
+	if _, ok := p.sources[directive]; !ok {
+		p.directives = append(p.directives, directive)
+	}
+	p.sources[directive] = append(p.sources[directive], sources...)
+	return p
+}
+
+// usesNonce checks whether any directive contains NonceSource
+func (p *CSP) usesNonce() bool {
+	for _, sources := range p.sources {
+		for _, source := range sources {
+			if source == NonceSource {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// header returns the policy with NonceSource replaced with a nonce
+func (p *CSP) header(nonce string) string {
+	parts := make([]string, 0, len(p.directives))
+	for _, directive := range p.directives {
+		sources := make([]string, 0, len(p.sources[directive]))
+		for _, source := range p.sources[directive] {
+			if source == NonceSource {
+				source = "'nonce-" + nonce + "'"
+			}
+			sources = append(sources, source)
+		}
+		parts = append(parts, strings.TrimSpace(directive+" "+strings.Join(sources, " ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// SecurityOptions configures the SecurityHeaders middleware. Empty fields
+// are not sent.
+type SecurityOptions struct {
+	// HSTSMaxAge is the max-age of Strict-Transport-Security in seconds
+	HSTSMaxAge            int
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// FrameOptions is X-Frame-Options: "DENY" or "SAMEORIGIN"
+	FrameOptions string
+	// ReferrerPolicy is Referrer-Policy: "strict-origin-when-cross-origin"
+	ReferrerPolicy string
+	// PermissionsPolicy is Permissions-Policy: "camera=(), microphone=()"
+	PermissionsPolicy string
+	// CSP is the Content-Security-Policy
+	CSP *CSP
+}
+
+// SecurityHeaders returns middleware sending security headers with every
+// response. X-Content-Type-Options: nosniff is always sent. If the CSP
+// contains NonceSource, a nonce is generated for each request, and it's
+// added to the script tags rendered via @js and @staticjs:
+// csp := gomvc.NewCSP().Add("script-src", gomvc.NonceSource)
+// gomvc.Use(gomvc.SecurityHeaders(gomvc.SecurityOptions{CSP: csp}))
+func SecurityHeaders(opts SecurityOptions) func(http.Handler) http.Handler {
+	hsts := ""
+	if opts.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(opts.HSTSMaxAge)
+		if opts.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if opts.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	cspHeader := "Content-Security-Policy"
+	if opts.CSP != nil && opts.CSP.ReportOnly {
+		cspHeader += "-Report-Only"
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			header.Set("X-Content-Type-Options", "nosniff")
+			if hsts != "" {
+				header.Set("Strict-Transport-Security", hsts)
+			}
+			if opts.FrameOptions != "" {
+				header.Set("X-Frame-Options", opts.FrameOptions)
+			}
+			if opts.ReferrerPolicy != "" {
+				header.Set("Referrer-Policy", opts.ReferrerPolicy)
+			}
+			if opts.PermissionsPolicy != "" {
+				header.Set("Permissions-Policy", opts.PermissionsPolicy)
+			}
+			if opts.CSP != nil {
+				nonce := ""
+				if opts.CSP.usesNonce() {
+					nonce = base64.RawStdEncoding.EncodeToString(
+						securecookie.GenerateRandomKey(16))
+					r = r.WithContext(context.WithValue(r.Context(), nonceKey, nonce))
+				}
+				header.Set(cspHeader, opts.CSP.header(nonce))
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSPNonce returns the nonce of the request's Content-Security-Policy, or an
+// empty string if SecurityHeaders doesn't use nonces. It's available in
+// templates for inline scripts: <script nonce="@csp_nonce">
+func (c *Controller) CSPNonce() string {
+	if c.Request == nil {
+		return ""
+	}
+	nonce, _ := c.Request.Context().Value(nonceKey).(string)
+	return nonce
+}
@@ -0,0 +1,64 @@
+package gomvc
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	config = &Config{IsDev: true}
+	csp := NewCSP().Add("default-src", "'self'").Add("script-src", "'self'", NonceSource)
+	var tag, timestamped, external template.HTML
+	h := SecurityHeaders(SecurityOptions{HSTSMaxAge: 600, CSP: csp})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := &Controller{Request: r}
+			tag = c.staticScriptTag("app.js")
+			timestamped = c.scriptTag("app.js")
+			external = c.scriptTag("//cdn.example.com/lib.js")
+		}))
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	h.ServeHTTP(w, r)
+	policy := w.Header().Get("Content-Security-Policy")
+	nonce := strings.TrimSuffix(strings.TrimPrefix(policy,
+		"default-src 'self'; script-src 'self' 'nonce-"), "'")
+	if nonce == policy || nonce == "" {
+		t.Fatalf("Content-Security-Policy = %q", policy)
+	}
+	if want := template.HTML("<script src='/js/app.js' nonce='" + nonce + "'></script>"); tag != want {
+		t.Errorf("staticjs = %q, want %q", tag, want)
+	}
+	want := fmt.Sprintf("<script src='/js/app.js?%d' nonce='%s'></script>", TimeStamp, nonce)
+	if string(timestamped) != want {
+		t.Errorf("js = %q, want %q", timestamped, want)
+	}
+	want = fmt.Sprintf("<script src='//cdn.example.com/lib.js?%d' nonce='%s'></script>", TimeStamp, nonce)
+	if string(external) != want {
+		t.Errorf("js with an external file = %q, want %q", external, want)
+	}
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "max-age=600" {
+		t.Errorf("Strict-Transport-Security = %q", hsts)
+	}
+}
+
+func TestStaticMiddleware(t *testing.T) {
+	defer func(old []func(http.Handler) http.Handler) { middlewares = old }(middlewares)
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "app.css"), []byte("body{}"), 0600)
+	// Middleware added after ServeStatic applies too
+	ServeStatic("static-test", dir)
+	Use(SecurityHeaders(SecurityOptions{FrameOptions: "DENY"}))
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/static-test/app.css", nil)
+	http.DefaultServeMux.ServeHTTP(w, r)
+	if w.Body.String() != "body{}" || w.Header().Get("X-Frame-Options") != "DENY" ||
+		w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("static file = %d %q with headers %v", w.Code, w.Body, w.Header())
+	}
+}
@@ -0,0 +1,159 @@
+package gomvc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrNoSessionValue is returned by SessionGet if there's no such key
+var ErrNoSessionValue = errors.New("gomvc: no such session value")
+
+// SessionSet stores a value of any type in the session. Values other than
+// strings are encoded as JSON, so that they don't have to be registered
+// with gob like with the gorilla sessions package.
+func (c *Controller) SessionSet(key string, value interface{}) error {
+	if s, ok := value.(string); ok {
+		c.Session[key] = s
+		return nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	c.gorillaSession.Values[key] = b
+	// Keep Session in sync, it's not written back since it's not modified
+	c.Session[key] = string(b)
+	c.sessionSnapshot[key] = string(b)
+	c.sessionDirty = true
+	return nil
+}
+
+// SessionGet decodes a session value into dst, which must be a pointer:
+// var cart Cart
+// err := c.SessionGet("cart", &cart)
+func (c *Controller) SessionGet(key string, dst interface{}) error {
+	val, ok := c.gorillaSession.Values[key]
+	if s, changed := c.Session[key]; changed && (!ok || s != c.sessionSnapshot[key]) {
+		// Set via Session in this request
+		val, ok = s, true
+	}
+	if !ok {
+		return ErrNoSessionValue
+	}
+	switch val := val.(type) {
+	case []byte:
+		return json.Unmarshal(val, dst)
+	case string:
+		if s, ok := dst.(*string); ok {
+			*s = val
+			return nil
+		}
+		// Numbers and booleans stored as strings: "5", "true"
+		return json.Unmarshal([]byte(val), dst)
+	}
+	// A value stored directly in the gorilla session
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("gomvc: SessionGet(%q) needs a pointer", key)
+	}
+	v := reflect.ValueOf(val)
+	if !v.Type().AssignableTo(dv.Elem().Type()) {
+		return fmt.Errorf("gomvc: session value %q is %T, not %s", key, val, dv.Elem().Type())
+	}
+	dv.Elem().Set(v)
+	return nil
+}
+
+// SessionInt returns a session value as an integer, or 0 if it's missing
+// or not a number
+func (c *Controller) SessionInt(key string) int {
+	var n int
+	c.SessionGet(key, &n)
+	return n
+}
+
+// SessionDelete removes a value from the session
+func (c *Controller) SessionDelete(key string) {
+	delete(c.Session, key)
+	delete(c.sessionSnapshot, key)
+	delete(c.gorillaSession.Values, key)
+	c.sessionDirty = true
+}
+
+// SessionClear removes all values from the session
+func (c *Controller) SessionClear() {
+	for key := range c.gorillaSession.Values {
+		delete(c.gorillaSession.Values, key)
+	}
+	c.Session = map[string]string{}
+	c.sessionSnapshot = map[string]string{}
+	c.sessionDirty = true
+}
+
+// RegenerateSession gives the session a new id, keeping its values. It
+// should be called after logging in to prevent session fixation: an id
+// planted by an attacker before the login becomes useless. Sessions of the
+// default CookieStore have no ids, so it does nothing with it.
+func (c *Controller) RegenerateSession() error {
+	if store, ok := sessionStore.(*ServerStore); ok && c.gorillaSession.ID != "" {
+		if err := store.Backend.Delete(c.gorillaSession.ID); err != nil {
+			return err
+		}
+	}
+	c.gorillaSession.ID = ""
+	c.sessionDirty = true
+	return nil
+}
+
+// loadSession fetches the session of the request. Session contains all
+// values as strings, typed values are accessed via SessionGet.
+func (c *Controller) loadSession() {
+	c.gorillaSession, _ = sessionStore.Get(c.Request, config.SessionID)
+	c.Session = make(map[string]string, len(c.gorillaSession.Values))
+	for key, val := range c.gorillaSession.Values {
+		if b, ok := val.([]byte); ok {
+			val = string(b)
+		}
+		c.Session[fmt.Sprintf("%v", key)] = fmt.Sprintf("%v", val)
+	}
+	c.sessionSnapshot = make(map[string]string, len(c.Session))
+	for key, val := range c.Session {
+		c.sessionSnapshot[key] = val
+	}
+}
+
+// syncSession writes values changed via Session to the gorilla session.
+// Values that haven't been changed keep their types.
+func (c *Controller) syncSession() {
+	for key, val := range c.Session {
+		if old, ok := c.sessionSnapshot[key]; !ok || old != val {
+			c.gorillaSession.Values[key] = val
+			c.sessionSnapshot[key] = val
+			c.sessionDirty = true
+		}
+	}
+	for key := range c.sessionSnapshot {
+		if _, ok := c.Session[key]; !ok {
+			delete(c.gorillaSession.Values, key)
+			delete(c.sessionSnapshot, key)
+			c.sessionDirty = true
+		}
+	}
+}
+
+// saveSession writes the session if it has been modified. It's called once
+// per request, right before the response headers are sent.
+func (c *Controller) saveSession() error {
+	if c.sessionSaved {
+		return nil
+	}
+	c.sessionSaved = true
+	c.keepFlashes()
+	c.syncSession()
+	if !c.sessionDirty {
+		return nil
+	}
+	return c.gorillaSession.Save(c.Request, c.Out)
+}
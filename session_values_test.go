@@ -0,0 +1,127 @@
+package gomvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+type testCart struct {
+	Items []string
+	Total float64
+}
+
+type sessionValuesTest struct{ *Controller }
+
+// sessionAction is run by sessionValuesTest.Index
+var sessionAction func(c *Controller)
+
+func (c *sessionValuesTest) Index() { sessionAction(c.Controller) }
+
+// sessionRequest runs an action with cookies and returns the cookies it
+// sets
+func sessionRequest(t *testing.T, cookies []*http.Cookie, action func(c *Controller)) []*http.Cookie {
+	ActionArgs = map[string]map[string][]string{"sessionValuesTest": {"Index": {}}}
+	sessionAction = action
+	r, _ := http.NewRequest("GET", "/Index", nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	GetHandler(&sessionValuesTest{})(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("the request failed: %d %s", w.Code, w.Body)
+	}
+	return w.Result().Cookies()
+}
+
+func TestSessionValues(t *testing.T) {
+	config = &Config{IsDev: true, SessionID: "s"}
+	memory := NewMemoryStore([]byte("secret"))
+	defer memory.Backend.(*MemoryBackend).Close()
+	stores := []sessions.Store{sessions.NewCookieStore([]byte("secret")), memory}
+	for _, store := range stores {
+		sessionStore = store
+		cart := testCart{Items: []string{"apple"}, Total: 1.5}
+		cookies := sessionRequest(t, nil, func(c *Controller) {
+			if err := c.SessionSet("cart", cart); err != nil {
+				t.Fatal(err)
+			}
+			c.SessionSet("count", 5)
+			c.Session["name"] = "bob"
+			var got testCart
+			if err := c.SessionGet("cart", &got); err != nil || !reflect.DeepEqual(got, cart) {
+				t.Errorf("%T: SessionGet() in the same request = %v, %v", store, got, err)
+			}
+		})
+		// Typed values survive a round trip, unchanged sessions are not saved
+		next := sessionRequest(t, cookies, func(c *Controller) {
+			var got testCart
+			if err := c.SessionGet("cart", &got); err != nil || !reflect.DeepEqual(got, cart) {
+				t.Errorf("%T: SessionGet() = %v, %v, want %v", store, got, err, cart)
+			}
+			var name string
+			if n := c.SessionInt("count"); n != 5 || c.Session["count"] != "5" {
+				t.Errorf("%T: SessionInt() = %d, Session = %q, want 5", store, n, c.Session["count"])
+			}
+			if err := c.SessionGet("name", &name); err != nil || name != "bob" {
+				t.Errorf("%T: SessionGet(name) = %q, %v, want bob", store, name, err)
+			}
+		})
+		if len(next) != 0 {
+			t.Errorf("%T: an unchanged session was saved", store)
+		}
+		// Values set via Session are read by SessionGet, deleted values are gone
+		cookies = sessionRequest(t, cookies, func(c *Controller) {
+			c.Session["count"] = "7"
+			if n := c.SessionInt("count"); n != 7 {
+				t.Errorf("%T: SessionInt() after Session[count] = 7 is %d", store, n)
+			}
+			c.SessionDelete("name")
+		})
+		cookies = sessionRequest(t, cookies, func(c *Controller) {
+			var name string
+			if err := c.SessionGet("name", &name); err != ErrNoSessionValue {
+				t.Errorf("%T: SessionGet() of a deleted value = %v", store, err)
+			}
+			if n := c.SessionInt("count"); n != 7 {
+				t.Errorf("%T: SessionInt() = %d, want 7", store, n)
+			}
+			c.SessionClear()
+		})
+		sessionRequest(t, cookies, func(c *Controller) {
+			if len(c.Session) != 0 {
+				t.Errorf("%T: Session = %v after SessionClear()", store, c.Session)
+			}
+		})
+	}
+}
+
+func TestRegenerateSession(t *testing.T) {
+	config = &Config{IsDev: true, SessionID: "s"}
+	backend := NewMemoryBackend(time.Minute)
+	defer backend.Close()
+	sessionStore = NewServerStore(backend, []byte("secret"))
+	var oldID string
+	cookies := sessionRequest(t, nil, func(c *Controller) { c.Session["user"] = "bob" })
+	cookies = sessionRequest(t, cookies, func(c *Controller) {
+		oldID = c.gorillaSession.ID
+		if err := c.RegenerateSession(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, err := backend.Load(oldID); oldID == "" || err != ErrSessionNotFound {
+		t.Errorf("the old session %q wasn't deleted: %v", oldID, err)
+	}
+	// The values are kept in the new session
+	sessionRequest(t, cookies, func(c *Controller) {
+		if id := c.gorillaSession.ID; id == "" || id == oldID || c.Session["user"] != "bob" {
+			t.Errorf("session %q = %v after RegenerateSession(), old id %q",
+				id, c.Session, oldID)
+		}
+	})
+}
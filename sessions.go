@@ -0,0 +1,461 @@
+package gomvc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// ownerKey is the session key with the id of the user the session belongs
+// to. It's used for deleting all sessions of a user.
+const ownerKey = "gomvc_owner"
+
+// ErrSessionNotFound is returned by SessionBackend.Load for unknown and
+// expired sessions
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionBackend stores session data on the server. Implement it to keep
+// sessions in Redis, an SQL database etc. MemoryBackend can be used as a
+// fake in tests of code that works with sessions.
+type SessionBackend interface {
+	// Load returns the data of a session or ErrSessionNotFound
+	Load(id string) ([]byte, error)
+	// Save stores the data of a session for ttl. owner is the id of the
+	// user the session belongs to, it's empty for anonymous sessions.
+	Save(id string, data []byte, owner string, ttl time.Duration) error
+	// Delete removes a session
+	Delete(id string) error
+	// DeleteOwner removes all sessions of a user
+	DeleteOwner(owner string) error
+}
+
+// ServerStore is a sessions.Store that keeps session data in a
+// SessionBackend. Cookies only contain signed session ids, so sessions are
+// not limited by the cookie size and can be revoked on the server.
+type ServerStore struct {
+	Backend SessionBackend
+	Codecs  []securecookie.Codec
+	// Options are set by Run if they are nil
+	Options *sessions.Options
+}
+
+// NewServerStore creates a store with a backend. Key pairs are used for
+// signing (and optionally encrypting) session id cookies, just like in
+// sessions.NewCookieStore.
+func NewServerStore(backend SessionBackend, keyPairs ...[]byte) *ServerStore {
+	return &ServerStore{
+		Backend: backend,
+		Codecs:  securecookie.CodecsFromPairs(keyPairs...),
+	}
+}
+
+// NewMemoryStore creates a store that keeps sessions in memory
+func NewMemoryStore(keyPairs ...[]byte) *ServerStore {
+	return NewServerStore(NewMemoryBackend(time.Minute), keyPairs...)
+}
+
+// NewFileStore creates a store that keeps sessions in files in a directory
+func NewFileStore(dir string, keyPairs ...[]byte) *ServerStore {
+	return NewServerStore(NewFileBackend(dir, time.Hour), keyPairs...)
+}
+
+// Get returns a session cached for the request or loads it
+func (s *ServerStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads a session by the id from the cookie, or creates a new one
+func (s *ServerStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.options()
+	session.Options = &opts
+	session.IsNew = true
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err = securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+	data, err := s.Backend.Load(session.ID)
+	if err == ErrSessionNotFound {
+		// The session has expired or has been deleted
+		session.ID = ""
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values); err != nil {
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save writes session data to the backend and the session id to the cookie.
+// Sessions with a negative MaxAge are deleted.
+func (s *ServerStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.Backend.Delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		session.ID = newSessionID()
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(session.Values); err != nil {
+		return err
+	}
+	owner, _ := session.Values[ownerKey].(string)
+	// Browser sessions (MaxAge=0) are kept on the server for a day
+	ttl := 24 * time.Hour
+	if session.Options.MaxAge > 0 {
+		ttl = time.Duration(session.Options.MaxAge) * time.Second
+	}
+	if err := s.Backend.Save(session.ID, buf.Bytes(), owner, ttl); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *ServerStore) options() *sessions.Options {
+	if s.Options == nil {
+		return defaultSessionOptions()
+	}
+	return s.Options
+}
+
+// defaultSessionOptions are used by the session stores created by Run
+func defaultSessionOptions() *sessions.Options {
+	opts := &sessions.Options{
+		Path:     "/",
+		MaxAge:   86400 * 30,           // Default session lasts 30 days
+		HttpOnly: true,                 // Do not allow the cookie to be read from JS
+		SameSite: http.SameSiteLaxMode, // Do not send the cookie with cross-site POSTs
+	}
+	if config == nil {
+		return opts
+	}
+	opts.Secure = !config.IsDev && !config.InsecureCookies // Use secure store in production only
+	opts.Domain = config.SessionDomain
+	if config.SessionSameSite != 0 {
+		opts.SameSite = config.SessionSameSite
+	}
+	switch {
+	case config.SessionMaxAge < 0:
+		opts.MaxAge = 0 // A browser session
+	case config.SessionMaxAge > 0:
+		opts.MaxAge = config.SessionMaxAge
+	}
+	return opts
+}
+
+// minSecretLen is the minimum length of session hash keys on production
+const minSecretLen = 32
+
+// sessionKeys returns the key pairs for signing and encrypting session
+// cookies: Config.SessionKeys or Config.SessionSecret. It panics on
+// production if the keys are missing or weak.
+func sessionKeys() [][]byte {
+	keys := config.SessionKeys
+	if len(keys) == 0 {
+		keys = [][]byte{[]byte(config.SessionSecret)}
+	}
+	for i, key := range keys {
+		if i%2 == 0 && len(key) < minSecretLen && !config.IsDev {
+			panic(fmt.Sprintf("Session hash keys must be at least %d bytes long, "+
+				"set Config.SessionSecret or Config.SessionKeys", minSecretLen))
+		}
+		if i%2 == 1 && key != nil {
+			switch len(key) {
+			case 16, 24, 32:
+			default:
+				panic("Session block keys must be 16, 24 or 32 bytes long")
+			}
+		}
+	}
+	return keys
+}
+
+// newSessionID generates a random session id
+func newSessionID() string {
+	return base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+}
+
+// validSessionID matches session ids generated by newSessionID. Ids are
+// validated before they are used in file names.
+var validSessionID = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// SetSessionOwner marks the session as belonging to a user, so that it can
+// be deleted via DeleteUserSessions
+func (c *Controller) SetSessionOwner(owner string) {
+	c.Session[ownerKey] = owner
+}
+
+// DeleteUserSessions deletes all sessions of a user ("log out all
+// devices"). It only works with stores that keep sessions on the server.
+func DeleteUserSessions(owner string) error {
+	store, ok := sessionStore.(*ServerStore)
+	if !ok {
+		return errors.New("gomvc: sessions can only be deleted with a ServerStore")
+	}
+	return store.Backend.DeleteOwner(owner)
+}
+
+// MemoryBackend keeps sessions in memory. They are lost on restart, so it's
+// mostly useful on dev, in tests and in apps running on a single server.
+type MemoryBackend struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+	cleaner  *cleaner
+}
+
+type memorySession struct {
+	data    []byte
+	owner   string
+	expires time.Time
+}
+
+// NewMemoryBackend creates a memory backend. Expired sessions are removed
+// every cleanup interval until the backend is closed.
+func NewMemoryBackend(cleanup time.Duration) *MemoryBackend {
+	b := &MemoryBackend{sessions: map[string]memorySession{}}
+	b.cleaner = startCleaner(cleanup, b.removeExpired)
+	return b
+}
+
+// Close stops removing expired sessions
+func (b *MemoryBackend) Close() error {
+	b.cleaner.stop()
+	return nil
+}
+
+func (b *MemoryBackend) Load(id string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.sessions[id]
+	if !ok || time.Now().After(s.expires) {
+		return nil, ErrSessionNotFound
+	}
+	return s.data, nil
+}
+
+func (b *MemoryBackend) Save(id string, data []byte, owner string, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sessions[id] = memorySession{data, owner, time.Now().Add(ttl)}
+	return nil
+}
+
+func (b *MemoryBackend) Delete(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.sessions, id)
+	return nil
+}
+
+func (b *MemoryBackend) DeleteOwner(owner string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, s := range b.sessions {
+		if s.owner == owner {
+			delete(b.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBackend) removeExpired() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for id, s := range b.sessions {
+		if now.After(s.expires) {
+			delete(b.sessions, id)
+		}
+	}
+}
+
+// FileBackend keeps each session in a file. The first line of a file
+// contains the expiration time and the owner, the rest is session data.
+type FileBackend struct {
+	dir     string
+	mu      sync.RWMutex
+	cleaner *cleaner
+}
+
+// NewFileBackend creates a file backend storing sessions in dir. Expired
+// sessions are removed every cleanup interval until the backend is closed.
+func NewFileBackend(dir string, cleanup time.Duration) *FileBackend {
+	handle(os.MkdirAll(dir, 0700))
+	b := &FileBackend{dir: dir}
+	b.cleaner = startCleaner(cleanup, b.removeExpired)
+	return b
+}
+
+// Close stops removing expired sessions
+func (b *FileBackend) Close() error {
+	b.cleaner.stop()
+	return nil
+}
+
+func (b *FileBackend) path(id string) (string, error) {
+	if !validSessionID.MatchString(id) {
+		return "", ErrSessionNotFound
+	}
+	return filepath.Join(b.dir, "session_"+id), nil
+}
+
+func (b *FileBackend) Load(id string) ([]byte, error) {
+	path, err := b.path(id)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	expires, _, data, err := readSessionFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expires) {
+		return nil, ErrSessionNotFound
+	}
+	return data, nil
+}
+
+// errOwnerLineBreak is returned when an owner would break the header line
+// of a session file
+var errOwnerLineBreak = errors.New("gomvc: session owners can't contain line breaks")
+
+func (b *FileBackend) Save(id string, data []byte, owner string, ttl time.Duration) error {
+	path, err := b.path(id)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(owner, "\r\n") {
+		return errOwnerLineBreak
+	}
+	header := fmt.Sprintf("%d %s\n", time.Now().Add(ttl).Unix(), owner)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return ioutil.WriteFile(path, append([]byte(header), data...), 0600)
+}
+
+func (b *FileBackend) Delete(id string) error {
+	path, err := b.path(id)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err = os.Remove(path); os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (b *FileBackend) DeleteOwner(owner string) error {
+	return b.removeIf(func(expires time.Time, o string) bool { return o == owner })
+}
+
+func (b *FileBackend) removeExpired() {
+	now := time.Now()
+	b.removeIf(func(expires time.Time, owner string) bool { return now.After(expires) })
+}
+
+// cleaner calls a cleanup function periodically until it's stopped
+type cleaner struct {
+	ticker *time.Ticker
+	done   chan struct{}
+	once   sync.Once
+}
+
+func startCleaner(interval time.Duration, clean func()) *cleaner {
+	c := &cleaner{ticker: time.NewTicker(interval), done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case <-c.ticker.C:
+				clean()
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// stop stops the cleaner, it can be called more than once
+func (c *cleaner) stop() {
+	c.once.Do(func() {
+		c.ticker.Stop()
+		close(c.done)
+	})
+}
+
+// removeIf removes sessions matching a condition
+func (b *FileBackend) removeIf(cond func(expires time.Time, owner string) bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	files, err := filepath.Glob(filepath.Join(b.dir, "session_*"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		expires, owner, _, err := readSessionFile(file)
+		if err == nil && cond(expires, owner) {
+			if err = os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readSessionFile parses a session file written by FileBackend.Save
+func readSessionFile(path string) (expires time.Time, owner string, data []byte, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	header, err := bufio.NewReader(bytes.NewReader(b)).ReadString('\n')
+	if err != nil {
+		return
+	}
+	fields := strings.SplitN(strings.TrimSuffix(header, "\n"), " ", 2)
+	unix, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || len(fields) < 2 {
+		err = fmt.Errorf("invalid session file %s", path)
+		return
+	}
+	return time.Unix(unix, 0), fields[1], b[len(header):], nil
+}
@@ -0,0 +1,219 @@
+package gomvc
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// testSessionBackend checks the SessionBackend contract. Custom backends
+// (Redis, SQL) can be tested the same way.
+func testSessionBackend(t *testing.T, b SessionBackend) {
+	if _, err := b.Load("unknown"); err != ErrSessionNotFound {
+		t.Errorf("Load(unknown) error = %v, want ErrSessionNotFound", err)
+	}
+	check := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(b.Save("a", []byte("data a"), "bob", time.Hour))
+	check(b.Save("b", []byte("data b"), "bob", time.Hour))
+	check(b.Save("c", []byte("data c"), "", time.Hour))
+	check(b.Save("expired", []byte("data"), "", -time.Second))
+	if data, err := b.Load("a"); err != nil || string(data) != "data a" {
+		t.Errorf("Load(a) = %q, %v, want %q", data, err, "data a")
+	}
+	if _, err := b.Load("expired"); err != ErrSessionNotFound {
+		t.Errorf("Load(expired) error = %v, want ErrSessionNotFound", err)
+	}
+	check(b.Delete("c"))
+	if _, err := b.Load("c"); err != ErrSessionNotFound {
+		t.Errorf("Load(c) after Delete error = %v, want ErrSessionNotFound", err)
+	}
+	check(b.DeleteOwner("bob"))
+	for _, id := range []string{"a", "b"} {
+		if _, err := b.Load(id); err != ErrSessionNotFound {
+			t.Errorf("Load(%s) after DeleteOwner error = %v, want ErrSessionNotFound", id, err)
+		}
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	b := NewMemoryBackend(time.Hour)
+	defer b.Close()
+	testSessionBackend(t, b)
+}
+
+func TestFileBackend(t *testing.T) {
+	b := NewFileBackend(t.TempDir(), time.Hour)
+	defer b.Close()
+	testSessionBackend(t, b)
+	if err := b.Save("a", nil, "bob\n1 eve", time.Hour); err != errOwnerLineBreak {
+		t.Errorf("Save() with a line break in the owner = %v, want an error", err)
+	}
+}
+
+func TestServerStore(t *testing.T) {
+	store := NewMemoryStore([]byte("secret"))
+	defer store.Backend.(*MemoryBackend).Close()
+	r, _ := http.NewRequest("GET", "/", nil)
+	session, err := store.Get(r, "s")
+	if err != nil || !session.IsNew {
+		t.Fatalf("Get() = %v, %v, want a new session", session, err)
+	}
+	session.Values["user"] = "bob"
+	session.Values["count"] = 5
+	w := httptest.NewRecorder()
+	if err = store.Save(r, w, session); err != nil {
+		t.Fatal(err)
+	}
+	// Load the session in the next request
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	session, err = store.Get(r, "s")
+	if err != nil || session.Values["user"] != "bob" || session.Values["count"] != 5 {
+		t.Errorf("Get() = %v, %v, want the saved session", session.Values, err)
+	}
+}
+
+// failingBackend fails to save sessions
+type failingBackend struct{ *MemoryBackend }
+
+func (b failingBackend) Save(id string, data []byte, owner string, ttl time.Duration) error {
+	return errors.New("disk full")
+}
+
+type sessionTest struct{ *Controller }
+
+func (c *sessionTest) Read() string  { return c.Session["user"] }
+func (c *sessionTest) Write() string { c.Session["user"] = "bob"; return "ok" }
+
+func TestSessionSave(t *testing.T) {
+	config = &Config{IsDev: true, SessionID: "s"}
+	ActionArgs = map[string]map[string][]string{
+		"sessionTest": {"Read": {}, "Write": {}},
+	}
+	handler := GetHandler(&sessionTest{})
+	tests := []struct {
+		backend SessionBackend
+		path    string
+		code    int
+		cookies int
+	}{
+		{NewMemoryBackend(time.Minute), "/Read", 200, 0},
+		{NewMemoryBackend(time.Minute), "/Write", 200, 1},
+		{failingBackend{NewMemoryBackend(time.Minute)}, "/Read", 200, 0},
+		{failingBackend{NewMemoryBackend(time.Minute)}, "/Write", 500, 0},
+	}
+	for _, test := range tests {
+		defer test.backend.(io.Closer).Close()
+		sessionStore = NewServerStore(test.backend, []byte("secret"))
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.path, nil)
+		handler(w, r)
+		if n := len(w.Result().Cookies()); w.Code != test.code || n != test.cookies {
+			t.Errorf("%s: got %d with %d cookies, want %d with %d", test.path,
+				w.Code, n, test.code, test.cookies)
+		}
+	}
+}
+
+// mustPanic checks that f panics
+func mustPanic(t *testing.T, name string, f func()) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s didn't panic", name)
+		}
+	}()
+	f()
+}
+
+func TestSessionKeys(t *testing.T) {
+	hashKey := []byte("0123456789abcdef0123456789abcdef")
+	config = &Config{SessionSecret: "short"}
+	mustPanic(t, "a short secret on production", func() { sessionKeys() })
+	config = &Config{SessionKeys: [][]byte{hashKey, []byte("10 bytes!!")}}
+	mustPanic(t, "a block key of 10 bytes", func() { sessionKeys() })
+	config = &Config{IsDev: true, SessionSecret: "short"}
+	if keys := sessionKeys(); len(keys) != 1 || string(keys[0]) != "short" {
+		t.Errorf("sessionKeys() = %q on dev, want the short secret", keys)
+	}
+	// Cookies encoded with old keys are accepted after a rotation
+	oldKeys := [][]byte{[]byte("old hash key, 32 bytes long!!!!!"), []byte("old block key 16")}
+	old, _ := securecookie.EncodeMulti("s", "value", securecookie.CodecsFromPairs(oldKeys...)...)
+	config = &Config{SessionKeys: append([][]byte{hashKey, nil}, oldKeys...)}
+	codecs := securecookie.CodecsFromPairs(sessionKeys()...)
+	var value string
+	if err := securecookie.DecodeMulti("s", old, &value, codecs...); err != nil || value != "value" {
+		t.Errorf("decoding a cookie with old keys = %q, %v", value, err)
+	}
+	current, _ := securecookie.EncodeMulti("s", "value", codecs...)
+	if securecookie.DecodeMulti("s", current, &value, codecs[0]) != nil {
+		t.Error("new cookies are not encoded with the first key pair")
+	}
+}
+
+func TestSessionOptions(t *testing.T) {
+	config = &Config{SessionMaxAge: -1, SessionDomain: "example.com",
+		SessionSameSite: http.SameSiteStrictMode}
+	opts := defaultSessionOptions()
+	if opts.MaxAge != 0 || opts.Domain != "example.com" ||
+		opts.SameSite != http.SameSiteStrictMode || !opts.Secure || !opts.HttpOnly {
+		t.Errorf("defaultSessionOptions() = %+v", opts)
+	}
+}
+
+func TestSessionMaxAge(t *testing.T) {
+	config = &Config{IsDev: true, SessionSecret: "secret", SessionMaxAge: 86400 * 90}
+	initSessionStore()
+	store := sessionStore.(*sessions.CookieStore)
+	// The codecs' limit is unexported, it's read via reflection
+	maxAge := reflect.ValueOf(store.Codecs[0]).Elem().FieldByName("maxAge").Int()
+	if store.Options.MaxAge != 86400*90 || maxAge != 86400*90 {
+		t.Errorf("MaxAge of options = %d, codecs = %d, want %d",
+			store.Options.MaxAge, maxAge, 86400*90)
+	}
+}
+
+// hijackRecorder is a response writer that can be hijacked
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (w *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return nil, nil, nil
+}
+
+func TestResponseWriterInterfaces(t *testing.T) {
+	config = &Config{IsDev: true, SessionID: "s"}
+	sessionStore = sessions.NewCookieStore([]byte("secret"))
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	c := &Controller{}
+	c.InitValues(w, r)
+	c.Session["user"] = "bob"
+	c.Out.(http.Flusher).Flush()
+	if !w.Flushed || len(w.Result().Cookies()) != 1 {
+		t.Errorf("Flush() didn't save the session and flush: %v", w.Result().Cookies())
+	}
+	if _, _, err := c.Out.(http.Hijacker).Hijack(); err != nil || !w.hijacked {
+		t.Errorf("Hijack() = %v, the wrapped writer wasn't hijacked", err)
+	}
+	// Writers that can't be hijacked return an error
+	c.InitValues(httptest.NewRecorder(), r)
+	if _, _, err := c.Out.(http.Hijacker).Hijack(); err == nil {
+		t.Error("Hijack() of a recorder didn't fail")
+	}
+}
@@ -4,10 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"io/ioutil"
 	"log"
-	"regexp"
 	"strings"
+	"time"
 )
 
 // Custom html/template functions
@@ -21,22 +20,6 @@ var defaultFuncs = template.FuncMap{
 		res := template.JS(out)
 		return res
 	},
-	"js": func(file string) template.HTML {
-		if strings.Index(file, "//") == -1 {
-			file = "/js/" + file
-		}
-		pos := strings.LastIndex(file, ".js")
-		if pos == -1 {
-			log.Println(file, "is not a JavaScript file")
-			return template.HTML("")
-		}
-		// Use minified JS on production
-		if !config.IsDev {
-			//file = file[:pos] + ".min.js"
-		}
-		file += fmt.Sprintf("?%d", TimeStamp)
-		return template.HTML("<script src='" + file + "'></script>")
-	},
 	"css": func(file string) template.HTML {
 		if strings.Index(file, "//") == -1 {
 			file = "/css/" + file
@@ -50,59 +33,174 @@ var defaultFuncs = template.FuncMap{
 		}
 		return template.HTML("<link href='" + file + "' rel='stylesheet'>")
 	},
-	"staticjs": func(file string) template.HTML {
-		if strings.Index(file, "//") == -1 {
-			file = "/js/" + file
-		}
-		return template.HTML("<script src='" + file + "'></script>")
-	},
-	//"flash" func() template.HTML { TODO
-	//},
 }
 
-// readTemplate reads a template file on dev, or an asset file on production
-// and returns its contents
-func readTemplate(path string) string {
+func init() {
+	// Declare request specific functions, so that templates can be parsed
+	// before any requests. They are replaced with functions bound to the
+	// request when a template is executed.
+	for name, f := range (&Controller{}).requestFuncs() {
+		defaultFuncs[name] = f
+	}
+}
+
+// requestFuncs returns template functions bound to the current request
+func (c *Controller) requestFuncs() template.FuncMap {
+	return template.FuncMap{
+		"component":   c.renderComponent,
+		"T":           c.T,
+		"flashes":     c.renderFlashes,
+		"csrf_field":  c.csrfInput,
+		"csrf_token":  c.CSRFToken,
+		"js":          c.scriptTag,
+		"staticjs":    c.staticScriptTag,
+		"csp_nonce":   c.CSPNonce,
+		"can":         c.Can,
+		"field_error": c.ModelState.fieldError,
+		"field_value": c.fieldValue,
+		"has_error":   c.ModelState.hasError,
+	}
+}
+
+// scriptTag renders a script tag for a file from /js/ with a timestamp
+// preventing caching of old versions
+func (c *Controller) scriptTag(file string) template.HTML {
+	if strings.Index(file, "//") == -1 {
+		file = "/js/" + file
+	}
+	pos := strings.LastIndex(file, ".js")
+	if pos == -1 {
+		log.Println(file, "is not a JavaScript file")
+		return template.HTML("")
+	}
+	// Use minified JS on production
+	if !config.IsDev {
+		//file = file[:pos] + ".min.js"
+	}
+	return c.scriptElement(file + fmt.Sprintf("?%d", TimeStamp))
+}
+
+// staticScriptTag renders a script tag for a file from /js/ without a
+// timestamp
+func (c *Controller) staticScriptTag(file string) template.HTML {
+	if strings.Index(file, "//") == -1 {
+		file = "/js/" + file
+	}
+	return c.scriptElement(file)
+}
+
+// scriptElement renders a script tag for a full path with the request's
+// CSP nonce
+func (c *Controller) scriptElement(src string) template.HTML {
+	nonce := ""
+	if n := c.CSPNonce(); n != "" {
+		nonce = " nonce='" + n + "'"
+	}
+	return template.HTML("<script src='" + src + "'" + nonce + "></script>")
+}
+
+// view is a template file converted to items of the gomvc syntax
+type view struct {
+	name     string
+	layout   string   // layout declared via @layout: "shared/_main.html"
+	partials []string // partials rendered via @partial
+	items    []item
+}
+
+// text returns the view in Go's template syntax. body is the template
+// rendered by @renderBody if the view is a layout.
+func (v *view) text(body string) string {
+	left, right := delims()
+	return generate(v.items, left, right, body)
+}
+
+// readView reads a template file on dev, or an asset file on production
+// and returns the parsed view and its modification time (zero for assets)
+func readView(path string) (*view, time.Time, error) {
+	var b []byte
+	var modTime time.Time
+	var err error
 	if !config.IsDev && config.AssetFunc != nil {
-		b, err := config.AssetFunc(path)
+		b, err = config.AssetFunc(path)
 		if err != nil {
-			log.Println("Asset error", err)
-			return ""
+			err = fmt.Errorf("%s: %w (%v)", path, errNoTemplate, err)
 		}
-		return convertTemplate(b)
+	} else {
+		b, modTime, err = readTemplateFile(path)
 	}
-	b, err := ioutil.ReadFile("v/" + path)
 	if err != nil {
-		log.Println("Reading template error", err)
-		return ""
+		return nil, modTime, err
 	}
-	return convertTemplate(b)
+	v, err := parseView(path, b)
+	return v, modTime, err
 }
 
-// convertTemplate applies custom structures and functions and converts a
-// custom template to Go's HTML template
-func convertTemplate(b []byte) string {
-	s := string(b)
-	// A helper s//g function
-	rreplace := func(r, replaceWith string) {
-		reg := regexp.MustCompile(r)
-		s = reg.ReplaceAllString(s, replaceWith)
+// parseView splits a template in gomvc syntax into items and finds the
+// layout it declares
+func parseView(name string, b []byte) (*view, error) {
+	left, right := delims()
+	items, err := lexTemplate(name, string(b), left, right)
+	if err != nil {
+		return nil, err
+	}
+	v := &view{name: name, items: items}
+	for _, it := range items {
+		if it.typ == itemPartial {
+			v.partials = append(v.partials, it.val)
+		}
+		if it.typ != itemLayout {
+			continue
+		}
+		if v.layout != "" {
+			return nil, fmt.Errorf("%s:%d: layout is declared twice", name, it.line)
+		}
+		v.layout = it.val
+		if !strings.HasSuffix(v.layout, ".html") {
+			v.layout += ".html"
+		}
+	}
+	return v, nil
+}
+
+// partialPath returns the path of a partial template. Partials without a
+// directory are stored in v/shared:
+// "_userCard" => "shared/_userCard.html"
+// "Home/_menu" => "Home/_menu.html"
+func partialPath(name string) string {
+	if !strings.Contains(name, "/") {
+		name = "shared/" + name
+	}
+	if !strings.HasSuffix(name, ".html") {
+		name += ".html"
+	}
+	return name
+}
+
+// delims returns the action delimiters, "{{" and "}}" by default
+func delims() (string, string) {
+	if config != nil && config.DelimLeft != "" {
+		return config.DelimLeft, config.DelimRight
+	}
+	return "{{", "}}"
+}
+
+// convertTemplate converts a template in gomvc syntax to Go's HTML template:
+// @Field => {{.Field}}
+// @$var => {{$var}}
+// @. => {{.}}
+// @if .Cond => {{ if .Cond }} (also else, end, range, with, define, block)
+// @t header => {{template "header"}}
+// @func "arg" .Field => {{ func "arg" .Field }}
+// %translation_key => {{ T "translation_key" }}
+// @partial "_userCard" .User => {{template "shared/_userCard.html" .User}}
+// @section scripts => {{define "section:scripts"}}
+// @renderSection "scripts" => {{template "section:scripts" .}}
+// @* comment *@ is removed, @@ and %% are escaped @ and %.
+// Line breaks are preserved, so errors point to lines in the source file.
+func convertTemplate(name string, b []byte) (string, error) {
+	v, err := parseView(name, b)
+	if err != nil {
+		return "", err
 	}
-	// Comments
-	rreplace(`@\*(.*?)\*@`, "")
-	// Template call
-	rreplace(`@t ([a-zA-Z_0-9]+)`, `{{template "$1"}}`)
-	// @.
-	rreplace(`@\.`, "{{.}}")
-	// @if @else etc
-	rreplace("@(if|else|end|range|template|define)(.*?)\n", "{{ $1 $2 }}\n")
-	// @Field
-	rreplace(`@([A-Z][0-9a-zA-Z\.]+)`, "{{.$1}}")
-	// @$variable
-	rreplace(`@([\$][0-9a-zA-Z\.]+)`, "{{$1}}")
-	// @func arg1 arg2
-	rreplace(`@([a-z][a-zA-Z\.]+( "[^"]+")*)`, "{{ $1 }}")
-	// %translation_key
-	rreplace("%([a-zA-Z_0-9]+)", `{{ T "$1" }}`)
-	return s
+	return v.text(""), nil
 }
@@ -0,0 +1,144 @@
+package gomvc
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConvertTemplate(t *testing.T) {
+	type testpair struct{ in, out string }
+
+	tests := []testpair{
+		{"Hello, @Name!", "Hello, {{.Name}}!"},
+		{"Hello, @User.Name.", "Hello, {{.User.Name}}."},
+		{"@.", "{{.}}"},
+		{"@$user.Email", "{{$user.Email}}"},
+		{"@if .IsAdmin\nadmin\n@else\nuser\n@end\n",
+			"{{ if .IsAdmin }}\nadmin\n{{ else }}\nuser\n{{ end }}\n"},
+		{"@range $i, $u := .Users\n@$u.Name\n@end",
+			"{{ range $i, $u := .Users }}\n{{$u.Name}}\n{{ end }}"},
+		{"@define header\n", "{{ define header }}\n"},
+		{"@t header", `{{template "header"}}`},
+		{`@js "app.js"`, `{{ js "app.js" }}`},
+		{`@css "a.css" "b.css"`, `{{ css "a.css" "b.css" }}`},
+		{`@printf "%d items" .Count`, `{{ printf "%d items" .Count }}`},
+		{`@add 1 2`, `{{ add 1 2 }}`},
+		{"%welcome_msg", `{{ T "welcome_msg" }}`},
+		{"<p>%user.greeting</p>", `<p>{{ T "user.greeting" }}</p>`},
+		{"a @* comment *@ b", "a  b"},
+		{"a @* multi\nline *@ b", "a \n b"},
+		{`{{ printf "%d" .X }} @Y`, `{{ printf "%d" .X }} {{.Y}}`},
+		{"@layout \"shared/_main\"\nbody", "\nbody"},
+		{"@section scripts\n<script></script>\n@end\n",
+			"{{define \"section:scripts\"}}\n<script></script>\n{{ end }}\n"},
+		{`@renderSection "scripts"`, `{{template "section:scripts" .}}`},
+		{`@renderSection "scripts" optional`,
+			`{{block "section:scripts" .}}{{end}}`},
+		{`@partial "_userCard" .User`, `{{template "shared/_userCard.html" .User}}`},
+		{`@partial "Home/_menu"`, `{{template "Home/_menu.html" .}}`},
+		{`@component "cart" .User`, `{{ component "cart" .User }}`},
+		// Plain text that must not be converted
+		{"Write to user@Example.com", "Write to user@Example.com"},
+		{"div { width: 100%; }", "div { width: 100%; }"},
+		{"50% off", "50% off"},
+		{"Follow @@gomvc", "Follow @gomvc"},
+		{"%%done", "%done"},
+		{"@ @1", "@ @1"},
+	}
+
+	for _, test := range tests {
+		res, err := convertTemplate("test.html", []byte(test.in))
+		if err != nil {
+			t.Errorf("convertTemplate(%q) error: %v", test.in, err)
+		} else if res != test.out {
+			t.Errorf("convertTemplate(%q) = %q, want %q", test.in, res, test.out)
+		}
+	}
+}
+
+func TestConvertTemplateErrors(t *testing.T) {
+	type testpair struct{ in, err string }
+
+	tests := []testpair{
+		{"a\nb @* comment", "Home/Index.html:2: unterminated comment"},
+		{"a\n\nb {{ .X", "Home/Index.html:3: unclosed action"},
+		{"\n@js \"app.js\n", "Home/Index.html:2: unterminated string"},
+		{"@layout main", "Home/Index.html:1: expected a quoted string"},
+		{"@layout \"a\"\n@layout \"b\"", "Home/Index.html:2: layout is declared twice"},
+	}
+
+	for _, test := range tests {
+		_, err := convertTemplate("Home/Index.html", []byte(test.in))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("convertTemplate(%q) error = %v, want %q", test.in, err, test.err)
+		}
+	}
+}
+
+func TestConvertTemplateLines(t *testing.T) {
+	in := "@* a\nb *@\n@if .X\n@Y\n@end\n%z\n"
+	res, err := convertTemplate("test.html", []byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(res, "\n") != strings.Count(in, "\n") {
+		t.Errorf("convertTemplate(%q) = %q, line breaks are not preserved", in, res)
+	}
+}
+
+func TestCustomTemplateFuncs(t *testing.T) {
+	config = &Config{IsDev: true}
+	writeViews(t, map[string]string{"funcTest/Index.html": `@shout "hi"`})
+	tests := []struct {
+		funcs template.FuncMap
+		out   string
+	}{
+		{template.FuncMap{"shout": strings.ToUpper}, "HI"},
+		{template.FuncMap{"shout": strings.ToLower}, "hi"},
+		// The cached template doesn't keep functions of other requests
+		{nil, `template function "shout" is not set in CustomTemplateFuncs`},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		c := &Controller{Out: w, ControllerName: "funcTest", ActionName: "Index",
+			CustomTemplateFuncs: test.funcs}
+		c.Render(nil)
+		if out := w.Body.String(); !strings.Contains(out, test.out) {
+			t.Errorf("Render() with %v = %q, want %q", test.funcs, out, test.out)
+		}
+	}
+}
+
+// greeter is a component greeting a user a number of times
+type greeter struct{}
+
+func (greeter) Render(c *Controller, args ...interface{}) (interface{}, string, error) {
+	return map[string]interface{}{"Name": args[0], "Times": args[1]}, "_greeting", nil
+}
+
+func TestComponentsAndPartials(t *testing.T) {
+	config = &Config{IsDev: true}
+	RegisterComponent("greeter", greeter{})
+	defer delete(components, "greeter")
+	writeViews(t, map[string]string{
+		"shared/_main.html":        "<main>@renderBody</main>",
+		"partialTest/Index.html":   "@layout \"shared/_main\"\n@component \"greeter\" \"Bob\" 2",
+		"shared/_greeting.html":    "Hi @Name, @Times times",
+		"partialTest/_status.html": "status: @.",
+	})
+	w := httptest.NewRecorder()
+	c := &Controller{Out: w, ControllerName: "partialTest", ActionName: "Index"}
+	c.Render(nil)
+	if out := w.Body.String(); out != "<main>\nHi Bob, 2 times</main>" {
+		t.Errorf("Render() with a component = %q", out)
+	}
+	// Partials are rendered without the layout
+	w = httptest.NewRecorder()
+	c = &Controller{Out: w, ControllerName: "partialTest", ActionName: "Index"}
+	c.renderResult(c.Partial("partialTest/_status", "ok").Header("X-Test", "1"))
+	if out := w.Body.String(); out != "status: ok" || w.Header().Get("X-Test") != "1" {
+		t.Errorf("Partial() = %q with %v", out, w.Header())
+	}
+}
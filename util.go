@@ -71,28 +71,32 @@ func replaceDashes(action string) string {
 	return res.String()
 }
 
-// getActionFromUri fetches an action name from uri:
-// "AccountController/Settings" => "Settings"
-// "Index" => "Index"
-// "" => "Index"
-// "Home/Register" => "Register"
-// "Forum/Topic/Hello-world/234242 => "Topic"
-func getActionFromUri(uri, controller string) string {
+// getActionFromUri fetches an action name and a format suffix from uri:
+// "AccountController/Settings" => "Settings", ""
+// "Index" => "Index", ""
+// "" => "Index", ""
+// "Home/Register" => "Register", ""
+// "Forum/Topic/Hello-world/234242 => "Topic", ""
+// "Home/Users.json" => "Users", "json"
+// Only the action can have a suffix, route variables after it are left
+// as they are: "Files/Download/data.json" => "Download", ""
+func getActionFromUri(uri, controller string) (string, string) {
 	// Root action
 	if uri == "" {
-		return "Index"
+		return "Index", ""
 	}
 	values := strings.Split(strings.Trim(uri, "/"), "/")
-	actionName := values[0]
-	// http://example.com/Controller/Action
-	if len(values) > 1 { // TODO this is ugly
-		if controller == "Home" {
-			actionName = values[0] // Save action, controller is skipped
-
-		} else {
-			actionName = values[1]
-		}
-	} else if len(values) == 1 && capitalize(actionName) == controller {
+	// http://example.com/Controller/Action, the Home controller is skipped:
+	// http://example.com/Action
+	i := 0
+	if len(values) > 1 && controller != "Home" { // TODO this is ugly
+		i = 1
+	}
+	actionName, format := values[i], ""
+	if i == len(values)-1 {
+		actionName, format = splitFormat(actionName)
+	}
+	if len(values) == 1 && capitalize(actionName) == controller {
 		// /Action => /Action/Index
 		actionName = "Index"
 	}
@@ -100,7 +104,57 @@ func getActionFromUri(uri, controller string) string {
 	actionName = capitalize(actionName)
 	actionName = strings.Replace(actionName, ".", "", -1)
 	actionName = replaceDashes(actionName)
-	return actionName
+	return actionName, format
+}
+
+// formatSuffixes are URL suffixes that select the format of a Negotiate
+// result
+var formatSuffixes = []string{"json", "xml"}
+
+// splitFormat separates a format suffix from an action:
+// "Users.json" => "Users", "json"
+// "Users" => "Users", ""
+func splitFormat(action string) (string, string) {
+	for _, format := range formatSuffixes {
+		if strings.HasSuffix(action, "."+format) {
+			return action[:len(action)-len(format)-1], format
+		}
+	}
+	return action, ""
+}
+
+// acceptFormats maps media types from the Accept header to response formats
+var acceptFormats = map[string]string{
+	"text/html":             "html",
+	"application/xhtml+xml": "html",
+	"*/*":                   "html",
+	"application/json":      "json",
+	"application/xml":       "xml",
+	"text/xml":              "xml",
+}
+
+// negotiateFormat picks a response format from the Accept header. The media
+// type with the highest quality wins, "html" is the default:
+// "application/json" => "json"
+// "text/xml;q=0.5, application/json;q=0.9" => "json"
+// "" => "html"
+func negotiateFormat(accept string) string {
+	format, best := "html", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q = tofloat(param[2:])
+			}
+		}
+		if f, ok := acceptFormats[mediaType]; ok && q > best {
+			format, best = f, q
+		}
+	}
+	return format
 }
 
 func handle(err error) {
@@ -1,9 +1,18 @@
 package gomvc
 
 import (
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 type Rule string
@@ -12,63 +21,647 @@ const (
 	Required  Rule = "Required"
 	MinLength Rule = "MinLength"
 	MaxLength Rule = "MaxLength"
+	Email     Rule = "Email"
+	URL       Rule = "URL"
+	Min       Rule = "Min"
+	Max       Rule = "Max"
+	Range     Rule = "Range"
+	Regex     Rule = "Regex"
+	In        Rule = "In"
+	EqualTo   Rule = "EqualTo"
+	Date      Rule = "Date"
+	Number    Rule = "Number"
+	// Optional skips the other rules of a field if it's empty
+	Optional Rule = "Optional"
 )
 
-var rules = []Rule{Required, MinLength, MaxLength}
+// RuleFunc checks the value of a field. param is the argument of the rule
+// ("5" in MinLength=5), parent is the struct containing the field.
+type RuleFunc func(value reflect.Value, param string, parent reflect.Value) bool
 
-// FormIsValid validates the form via fields' tags  containing rules like
-// "Required", "MinLength", etc
+// ruleDef is a validation rule and its default message. Messages can use
+// {field} and {param}, Range also uses {min} and {max}. Default messages
+// are used when the app's locale files don't have the rule's key:
+// "validation.min_length".
+type ruleDef struct {
+	check   RuleFunc
+	message string
+}
+
+var rules = map[Rule]ruleDef{
+	Required:  {checkRequired, "{field} is required"},
+	MinLength: {checkMinLength, "{field} must be at least {param} characters long"},
+	MaxLength: {checkMaxLength, "{field} must be at most {param} characters long"},
+	Email:     {checkEmail, "{field} must be a valid email address"},
+	URL:       {checkURL, "{field} must be a valid URL"},
+	Min:       {checkMin, "{field} must be at least {param}"},
+	Max:       {checkMax, "{field} must be at most {param}"},
+	Range:     {checkRange, "{field} must be between {min} and {max}"},
+	Regex:     {checkRegex, "{field} is invalid"},
+	In:        {checkIn, "{field} must be one of {param}"},
+	EqualTo:   {checkEqualTo, "{field} must match {param}"},
+	Date:      {checkDate, "{field} must be a valid date"},
+	Number:    {checkNumber, "{field} must be a number"},
+	Optional:  {checkOptional, ""},
+}
+
+// RegisterRule adds a custom validation rule. It must be called before
+// Run. The message can use {field} and {param}, it's used if the locale
+// files don't have a translation of "validation.<snake_case_name>":
+// gomvc.RegisterRule("Slug", checkSlug, "{field} can only contain a-z and -")
+func RegisterRule(name Rule, check RuleFunc, message string) {
+	rules[name] = ruleDef{check, message}
+}
+
+// ValidationError is a rule a field doesn't pass
+type ValidationError struct {
+	// Field is the path of the field: "Email", "Address.City", "Items[0].Name"
+	Field string
+	Rule  Rule
+	Param string
+	// Key is the i18n key of the message: the one set in the tag, or
+	// "validation.<rule>" like "validation.min_length"
+	Key string
+	// Message is the translated message
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors are validation errors keyed by field paths
+type ValidationErrors map[string][]ValidationError
+
+func (e ValidationErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	msgs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		for _, err := range e[field] {
+			msgs = append(msgs, err.Message)
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// First returns the first error message of a field or an empty string
+func (e ValidationErrors) First(field string) string {
+	if len(e[field]) == 0 {
+		return ""
+	}
+	return e[field][0].Message
+}
+
+// Validate checks all fields of a struct via rules in their tags and
+// returns all errors, or nil if the struct is valid. Rules are separated by
+// spaces or line breaks and can have an argument and a message key:
+// Name string `Required MinLength=3(errors.name_too_short)`
+// Code string `Regex="^[A-Z]{3}$"(errors.invalid_code)`
+// Rules can also be put in a "validate" tag, so that they don't clash with
+// other tags and go vet: `json:"name" validate:"Required MinLength=3"`.
+// Nested structs and slices of structs are validated too. Rules are
+// checked on empty fields as well, so that `MinLength=5` fails on an empty
+// string, unless the field is Optional: `validate:"Optional Email"`.
+//
+// Messages are translated via the locale files, they can use {field},
+// {param}, {min} and {max}. The field's name in messages is set via the
+// "label" tag, which is translated as well: `label:"fields.email"`.
+// Validate uses Config.DefaultLocale, c.Validate uses the request's locale.
+//
+// Invalid rules, e.g. an unknown rule or a Regex that doesn't compile, are
+// logged and reported as errors of their fields. Run checks the rules of
+// actions' forms and panics if they are invalid.
+func Validate(v interface{}) ValidationErrors {
+	locale := ""
+	if config != nil {
+		locale = config.DefaultLocale
+	}
+	return validateLocale(v, locale)
+}
+
+// Validate validates a struct like the Validate function, with messages in
+// the request's locale
+func (c *Controller) Validate(v interface{}) ValidationErrors {
+	return validateLocale(v, c.Locale)
+}
+
+func validateLocale(v interface{}, locale string) ValidationErrors {
+	res, err := validate(v, locale)
+	if err != nil {
+		log.Println(err)
+	}
+	errs := ValidationErrors{}
+	for _, err := range res {
+		errs[err.Field] = append(errs[err.Field], err)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// FormIsValid validates the form via fields' tags containing rules like
+// "Required", "MinLength", etc. Only the first error message is returned,
+// use Validate to get all of them. Message keys from tags that are not
+// translated are returned as is.
 func FormIsValid(f interface{}) (ok bool, errormsg string) {
-	typ := reflect.TypeOf(f).Elem()
-	val := reflect.ValueOf(f).Elem()
-	// Check all attributes
+	locale := ""
+	if config != nil {
+		locale = config.DefaultLocale
+	}
+	errs, err := validate(f, locale)
+	if err != nil {
+		log.Println(err)
+	}
+	if len(errs) > 0 {
+		if err := errs[0]; err.Key != "" && err.Key != ruleKey(err.Rule) &&
+			!hasMessage(locale, err.Key) {
+			return false, err.Key
+		}
+		return false, errs[0].Message
+	}
+	return true, ""
+}
+
+// validate returns validation errors in the order of fields with messages
+// in a locale. The error is the first invalid rule found in tags.
+func validate(v interface{}, locale string) ([]ValidationError, error) {
+	val := reflect.ValueOf(v)
+	if reflect.Indirect(val).Kind() != reflect.Struct {
+		return nil, nil
+	}
+	vd := &validation{locale: locale, visited: map[visit]bool{}}
+	if val.Kind() == reflect.Ptr {
+		vd.validateNested(val, "")
+	} else {
+		vd.validateStruct(val, "")
+	}
+	return vd.errs, vd.tagErr
+}
+
+// timeType is not validated as a nested struct
+var timeType = reflect.TypeOf(time.Time{})
+
+// validation is the state of validating a struct
+type validation struct {
+	locale string
+	errs   []ValidationError
+	tagErr error
+	// visited pointers, so that cyclic structs are validated once
+	visited map[visit]bool
+}
+
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+func (vd *validation) validateStruct(val reflect.Value, prefix string) {
+	typ := val.Type()
+	fr := rulesOf(typ)
 	for i := 0; i < typ.NumField(); i++ {
-		tag := string(typ.Field(i).Tag)
-		field := val.Field(i).String()
-		//fieldType := field.Type().Name()
-		// Test for all possible rules
-		for _, rule := range rules {
-			if ok, msg := test(tag, rule, field); !ok {
-				return false, msg
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			// Unexported field
+			continue
+		}
+		field := val.Field(i)
+		path := prefix + f.Name
+		if err := fr.errs[i]; err != nil {
+			// The field can't be checked, so it's not valid
+			vd.errs = append(vd.errs, ValidationError{Field: path, Message: err.Error()})
+			if vd.tagErr == nil {
+				vd.tagErr = err
 			}
+			continue
 		}
+		// Nil pointers have no value to check. Empty strings and slices are
+		// checked unless the field is Optional.
+		skip := (field.Kind() == reflect.Ptr || field.Kind() == reflect.Interface) &&
+			field.IsNil() || hasRule(fr.rules[i], Optional) && isEmpty(field)
+		for _, tr := range fr.rules[i] {
+			if tr.rule != Required && skip {
+				continue
+			}
+			if rules[tr.rule].check(field, tr.param, val) {
+				continue
+			}
+			param := tr.param
+			if tr.rule == EqualTo {
+				// "Password2 must match Password" uses labels of both fields
+				other, _ := typ.FieldByName(param)
+				param = fieldLabel(other, vd.locale)
+			}
+			vd.errs = append(vd.errs, newValidationError(vd.locale, path,
+				fieldLabel(f, vd.locale), tr.rule, tr.param, param, tr.message))
+		}
+		vd.validateNested(field, path)
 	}
-	return true, ""
 }
 
-// test runs the validation for the rule if it's specified. If the test was passed,
-// true is returned. Otherwise it's false and the error message set in the field tag.
-func test(tag string, rule Rule, val string) (bool, string) {
-	lines := strings.Split(tag, "\n")
-	for _, line := range lines {
-		// Search for a line that contains the rule specified
-		if strings.Index(line, string(rule)) == -1 {
+// hasRule checks whether a field has a rule
+func hasRule(trs []tagRule, rule Rule) bool {
+	for _, tr := range trs {
+		if tr.rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// validateNested validates structs, pointers to structs and slices of
+// structs
+func (vd *validation) validateNested(field reflect.Value, path string) {
+	switch field.Kind() {
+	case reflect.Ptr:
+		if field.IsNil() {
+			return
+		}
+		v := visit{field.Pointer(), field.Type()}
+		if vd.visited[v] {
+			return
+		}
+		vd.visited[v] = true
+		vd.validateNested(field.Elem(), path)
+	case reflect.Struct:
+		if field.Type() == timeType {
+			return
+		}
+		if path != "" {
+			path += "."
+		}
+		vd.validateStruct(field, path)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < field.Len(); i++ {
+			vd.validateNested(field.Index(i), path+"["+strconv.Itoa(i)+"]")
+		}
+	}
+}
+
+// fieldRules are the parsed rules of a struct's fields and errors of
+// fields with invalid rules
+type fieldRules struct {
+	rules [][]tagRule
+	errs  []error
+}
+
+// structRules caches fieldRules by struct types
+var structRules sync.Map
+
+// rulesOf parses and checks the rules of a struct type's fields once
+func rulesOf(typ reflect.Type) *fieldRules {
+	if fr, ok := structRules.Load(typ); ok {
+		return fr.(*fieldRules)
+	}
+	fr := &fieldRules{
+		rules: make([][]tagRule, typ.NumField()),
+		errs:  make([]error, typ.NumField()),
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
 			continue
 		}
-		// Parse the rules. Some examples:
-		// `Required(error_msg)`
-		// `MinLength=5(error_msg)`
-		r := regexp.MustCompile(`[a-zA-Z]+(=[0-9]+)?\(([a-z_]+)\)`)
-		matches := r.FindAllStringSubmatch(line, -1)
-		// Get integer argument if it's present
-		// In the above MinLength example, the argument is 5 (chars)
-		arg := matches[0][1]
-		intArg := 0
-		if arg != "" {
-			intArg = toint(arg[1:])
-		}
-		// Get the error message in the parenthesis
-		errorMsg := matches[0][2]
-		// Now perform the check
-		switch rule {
-		case Required:
-			return val != "", errorMsg
-		case MinLength:
-			return len(val) >= intArg, errorMsg
-		case MaxLength:
-			return len(val) <= intArg, errorMsg
-		}
-	}
-	// Specified rule was not found, so the test can't fail
-	return true, ""
+		fr.rules[i] = parseRules(f.Tag)
+		fr.errs[i] = checkRules(typ, f, fr.rules[i])
+	}
+	structRules.Store(typ, fr)
+	return fr
+}
+
+// checkRules returns an error for unknown rules, Regex arguments that
+// don't compile and EqualTo arguments that are not exported fields of the
+// struct
+func checkRules(typ reflect.Type, f reflect.StructField, trs []tagRule) error {
+	for _, tr := range trs {
+		var err error
+		if _, ok := rules[tr.rule]; !ok {
+			err = fmt.Errorf("unknown validation rule %q", tr.rule)
+		} else if tr.rule == Regex {
+			var r *regexp.Regexp
+			if r, err = regexp.Compile(tr.param); err == nil {
+				regexes.Store(tr.param, r)
+			}
+		} else if other, ok := typ.FieldByName(tr.param); tr.rule == EqualTo && !ok {
+			err = fmt.Errorf("EqualTo: there's no field %s", tr.param)
+		} else if tr.rule == EqualTo && other.PkgPath != "" {
+			err = fmt.Errorf("EqualTo: field %s is unexported", tr.param)
+		}
+		if err != nil {
+			return fmt.Errorf("gomvc: %s.%s: %v", typ.Name(), f.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkFormRules checks the rules of forms taken by actions of a
+// controller, including nested structs. The first invalid rule is
+// returned.
+func checkFormRules(controller reflect.Type) error {
+	seen := map[reflect.Type]bool{}
+	for i := 0; i < controller.NumMethod(); i++ {
+		method := controller.Method(i).Type
+		// The first argument is the receiver
+		for j := 1; j < method.NumIn(); j++ {
+			if arg := method.In(j); arg.Kind() == reflect.Ptr &&
+				arg.Elem().Kind() == reflect.Struct {
+				if err := checkTypeRules(arg.Elem(), seen); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkTypeRules(typ reflect.Type, seen map[reflect.Type]bool) error {
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice ||
+		typ.Kind() == reflect.Array {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || typ == timeType || seen[typ] {
+		return nil
+	}
+	seen[typ] = true
+	fr := rulesOf(typ)
+	for i := 0; i < typ.NumField(); i++ {
+		if fr.errs[i] != nil {
+			return fr.errs[i]
+		}
+		if typ.Field(i).PkgPath == "" {
+			if err := checkTypeRules(typ.Field(i).Type, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newValidationError creates an error with a message translated to a
+// locale. key is the message key from the tag, the rule's key is used if
+// it's empty. displayParam is the rule's argument shown in the message.
+func newValidationError(locale, path, label string, rule Rule, param, displayParam, key string) ValidationError {
+	min, max := splitParam(param)
+	params := map[string]interface{}{
+		"field": label, "param": displayParam, "min": min, "max": max}
+	err := ValidationError{Field: path, Rule: rule, Param: param, Key: key}
+	if key == "" {
+		err.Key = ruleKey(rule)
+	}
+	// Keys from tags that are not translated fall back to the rule's message
+	err.Message = translateOr(locale, err.Key, rules[rule].message, params)
+	return err
+}
+
+// ruleKey returns the i18n key of a rule's message: "validation.min_length"
+func ruleKey(rule Rule) string {
+	return "validation." + snakeCase(string(rule))
+}
+
+// fieldLabel returns the name of a field shown in messages: the translated
+// "label" tag, or the field's name if there's no tag or translation
+func fieldLabel(f reflect.StructField, locale string) string {
+	label := f.Tag.Get("label")
+	if label == "" {
+		return f.Name
+	}
+	return translateOr(locale, label, f.Name, nil)
+}
+
+// snakeCase converts a rule name to snake case: "MinLength" => "min_length",
+// "URL" => "url"
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isUpper(c) {
+			// A new word starts at an upper case letter after a lower case
+			// one, or before a lower case one in an acronym: "HTTPCode"
+			if i > 0 && (isLower(s[i-1]) ||
+				i+1 < len(s) && isLower(s[i+1]) && isUpper(s[i-1])) {
+				b.WriteByte('_')
+			}
+			c += 'a' - 'A'
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// tagRule is a rule parsed from a tag: MinLength=5(error_msg)
+type tagRule struct {
+	rule    Rule
+	param   string
+	message string
+}
+
+// parseRules parses validation rules from a field's tag. key:"value" pairs
+// of other packages are skipped, except for "validate".
+func parseRules(tag reflect.StructTag) []tagRule {
+	var res []tagRule
+	s := string(tag)
+	if v, ok := tag.Lookup("validate"); ok {
+		s = v
+	}
+	for pos := 0; pos < len(s); {
+		if c := s[pos]; !isLetter(c) {
+			pos++
+			continue
+		}
+		start := pos
+		for pos < len(s) && isAlphaNumeric(s[pos]) {
+			pos++
+		}
+		tr := tagRule{rule: Rule(s[start:pos])}
+		if pos < len(s) && s[pos] == ':' {
+			// key:"value" of another package
+			pos++
+			if rest := s[pos:]; strings.HasPrefix(rest, `"`) {
+				if quoted, err := strconv.QuotedPrefix(rest); err == nil {
+					pos += len(quoted)
+				}
+			}
+			continue
+		}
+		if pos < len(s) && s[pos] == '=' {
+			pos++
+			if quoted, err := strconv.QuotedPrefix(s[pos:]); err == nil {
+				// Quoted arguments can contain spaces and parentheses
+				tr.param, _ = strconv.Unquote(quoted)
+				pos += len(quoted)
+			} else {
+				start := pos
+				for pos < len(s) && s[pos] != '(' && !isSpace(s[pos]) {
+					pos++
+				}
+				tr.param = s[start:pos]
+			}
+		}
+		if pos < len(s) && s[pos] == '(' {
+			end := strings.IndexByte(s[pos:], ')')
+			if end == -1 {
+				end = len(s) - pos
+			}
+			tr.message = strings.TrimSpace(s[pos+1 : pos+end])
+			pos += end + 1
+		}
+		res = append(res, tr)
+	}
+	return res
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isEmpty checks whether a field has no value: an empty string, a nil
+// pointer or an empty slice. Zero numbers are values.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// splitParam splits a "min,max" argument
+func splitParam(param string) (string, string) {
+	parts := strings.SplitN(param, ",", 2)
+	if len(parts) < 2 {
+		return param, ""
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// length returns the number of characters of a string or the length of a
+// slice
+func length(v reflect.Value) int {
+	if v.Kind() == reflect.String {
+		return utf8.RuneCountInString(v.String())
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len()
+	}
+	return 0
+}
+
+// number converts a numeric value (or a string with a number) to float64
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.String()), 64)
+		return f, err == nil
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return number(v.Elem())
+		}
+	}
+	return 0, false
+}
+
+func checkRequired(v reflect.Value, param string, parent reflect.Value) bool {
+	if v.Kind() == reflect.String {
+		return strings.TrimSpace(v.String()) != ""
+	}
+	return !v.IsZero() && !isEmpty(v)
+}
+
+func checkOptional(v reflect.Value, param string, parent reflect.Value) bool {
+	return true
+}
+
+func checkMinLength(v reflect.Value, param string, parent reflect.Value) bool {
+	return length(v) >= toint(param)
+}
+
+func checkMaxLength(v reflect.Value, param string, parent reflect.Value) bool {
+	return length(v) <= toint(param)
+}
+
+func checkEmail(v reflect.Value, param string, parent reflect.Value) bool {
+	s := v.String()
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s && strings.Contains(s[strings.Index(s, "@"):], ".")
+}
+
+func checkURL(v reflect.Value, param string, parent reflect.Value) bool {
+	u, err := url.Parse(v.String())
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func checkMin(v reflect.Value, param string, parent reflect.Value) bool {
+	n, ok := number(v)
+	return ok && n >= tofloat(param)
+}
+
+func checkMax(v reflect.Value, param string, parent reflect.Value) bool {
+	n, ok := number(v)
+	return ok && n <= tofloat(param)
+}
+
+func checkRange(v reflect.Value, param string, parent reflect.Value) bool {
+	min, max := splitParam(param)
+	n, ok := number(v)
+	return ok && n >= tofloat(min) && n <= tofloat(max)
+}
+
+// regexes caches compiled Regex arguments, they are compiled when tags are
+// checked
+var regexes sync.Map
+
+func checkRegex(v reflect.Value, param string, parent reflect.Value) bool {
+	r, ok := regexes.Load(param)
+	if !ok {
+		return false
+	}
+	return r.(*regexp.Regexp).MatchString(fmt.Sprint(v.Interface()))
+}
+
+func checkIn(v reflect.Value, param string, parent reflect.Value) bool {
+	s := fmt.Sprint(reflect.Indirect(v).Interface())
+	for _, option := range strings.Split(param, ",") {
+		if strings.TrimSpace(option) == s {
+			return true
+		}
+	}
+	return false
+}
+
+func checkEqualTo(v reflect.Value, param string, parent reflect.Value) bool {
+	other := parent.FieldByName(param)
+	if !other.IsValid() || !other.CanInterface() {
+		return false
+	}
+	return reflect.DeepEqual(v.Interface(), other.Interface())
+}
+
+// checkDate checks a date string. The argument is a time.Parse layout,
+// "2006-01-02" by default.
+func checkDate(v reflect.Value, param string, parent reflect.Value) bool {
+	if v.Type() == timeType {
+		return true
+	}
+	if param == "" {
+		param = "2006-01-02"
+	}
+	_, err := time.Parse(param, v.String())
+	return err == nil
+}
+
+func checkNumber(v reflect.Value, param string, parent reflect.Value) bool {
+	_, ok := number(v)
+	return ok
 }
@@ -0,0 +1,268 @@
+package gomvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type testAddress struct {
+	City string `validate:"Required"`
+	Zip  string `validate:"Optional Regex=\"^[0-9]{5}$\"(invalid_zip)"`
+}
+
+type testForm struct {
+	Name      string  `json:"name" validate:"Required MinLength=3"`
+	Email     string  `validate:"Required(email_required) Email(invalid_email)"`
+	Website   string  `validate:"Optional URL"`
+	Age       int     `validate:"Range=18,99"`
+	Score     float64 `validate:"Min=0 Max=10"`
+	Role      string  `validate:"Optional In=admin,user"`
+	Password  string
+	Password2 string `validate:"EqualTo=Password"`
+	Birthday  string `validate:"Optional Date"`
+	Address   testAddress
+	Items     []testAddress
+}
+
+func TestValidate(t *testing.T) {
+	valid := testForm{Name: "Bob", Email: "bob@example.com", Age: 30,
+		Role: "user", Password: "x", Password2: "x", Birthday: "1990-01-31",
+		Address: testAddress{City: "Paris"}}
+	if errs := Validate(&valid); errs != nil {
+		t.Errorf("Validate() = %v for a valid form", errs)
+	}
+	invalid := testForm{Name: "Bo", Email: "bob@", Website: "javascript:alert(1)",
+		Age: 17, Score: 11, Role: "root", Password: "x", Password2: "y",
+		Birthday: "31.01.1990", Address: testAddress{Zip: "1234"},
+		Items: []testAddress{{City: "Paris"}, {}}}
+	want := map[string][]Rule{
+		"Name":          {MinLength},
+		"Email":         {Email},
+		"Website":       {URL},
+		"Age":           {Range},
+		"Score":         {Max},
+		"Role":          {In},
+		"Password2":     {EqualTo},
+		"Birthday":      {Date},
+		"Address.City":  {Required},
+		"Address.Zip":   {Regex},
+		"Items[1].City": {Required},
+	}
+	errs := Validate(&invalid)
+	got := map[string][]Rule{}
+	for field, fieldErrs := range errs {
+		for _, err := range fieldErrs {
+			got[field] = append(got[field], err.Rule)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate() = %v, want %v", got, want)
+	}
+	if msg := errs.First("Age"); msg != "Age must be between 18 and 99" {
+		t.Errorf("default message = %q", msg)
+	}
+	// Untranslated keys fall back to default messages
+	if msg := errs.First("Address.Zip"); msg != "Zip is invalid" {
+		t.Errorf("tag message = %q", msg)
+	}
+	// Rules can be written without the "validate" key
+	tr := parseRules("MinLength=5(too_short)\n\tRegex=\"^(a|b)$\" Required")
+	wantRules := []tagRule{{MinLength, "5", "too_short"}, {Regex, "^(a|b)$", ""},
+		{Required, "", ""}}
+	if !reflect.DeepEqual(tr, wantRules) {
+		t.Errorf("parseRules() = %v, want %v", tr, wantRules)
+	}
+	if ok, msg := FormIsValid(&testForm{Name: "Bob"}); ok || msg != "email_required" {
+		t.Errorf("FormIsValid() = %v, %q, want email_required", ok, msg)
+	}
+}
+
+func TestValidateEmptyFields(t *testing.T) {
+	type form struct {
+		Code    string `validate:"MinLength=5(code_too_short)"`
+		Website string `validate:"Optional URL"`
+		Age     *int   `validate:"Min=18"`
+	}
+	// Rules are checked on empty fields unless they are Optional
+	if ok, msg := FormIsValid(&form{}); ok || msg != "code_too_short" {
+		t.Errorf("FormIsValid() = %v, %q, want code_too_short", ok, msg)
+	}
+	if ok, msg := FormIsValid(&form{Code: "12345"}); !ok {
+		t.Errorf("FormIsValid() = false, %q for empty Optional and nil fields", msg)
+	}
+	if ok, _ := FormIsValid(&form{Code: "12345", Website: "example"}); ok {
+		t.Error("FormIsValid() = true for an invalid Optional field")
+	}
+}
+
+type modelStateTest struct{ *Controller }
+
+func (c *modelStateTest) SavePOST(form *testForm) string {
+	if !c.ModelState.Valid {
+		return "invalid: " + c.ModelState.Errors.First("Age")
+	}
+	return form.Address.City
+}
+
+func TestModelState(t *testing.T) {
+	config = &Config{IsDev: true, DisableCSRF: true}
+	ActionArgs = map[string]map[string][]string{"modelStateTest": {"SavePOST": {"form"}}}
+	tests := []struct {
+		form map[string]string
+		out  string
+	}{
+		{map[string]string{"name": "Bob", "email": "bob@example.com",
+			"age": "30", "address.city": "Paris"}, "Paris"},
+		{map[string]string{"name": "Bob", "email": "bob@example.com",
+			"age": "abc", "address.city": "Paris"}, "invalid: Age must be a number"},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", "/Save", nil)
+		w := httptest.NewRecorder()
+		c := &Controller{Request: r, Out: w, Form: test.form,
+			ControllerName: "modelStateTest", ActionName: "SavePOST"}
+		runMethod(reflect.ValueOf(&modelStateTest{c}).MethodByName("SavePOST"), c)
+		if w.Body.String() != test.out {
+			t.Errorf("SavePOST(%v) = %q, want %q", test.form, w.Body.String(), test.out)
+		}
+	}
+	// Invalid values are shown as they were submitted
+	c := &Controller{Form: tests[1].form}
+	c.ModelState.AddError("Email", "taken")
+	funcs := c.requestFuncs()
+	if v := funcs["field_value"].(func(string) string)("Age"); v != "abc" {
+		t.Errorf("field_value = %q, want abc", v)
+	}
+	if e := funcs["field_error"].(func(string) string)("Email"); e != "taken" {
+		t.Errorf("field_error = %q, want taken", e)
+	}
+}
+
+type testSignup struct {
+	Email     string `validate:"Required" label:"fields.email"`
+	Password  string `validate:"MinLength=8" label:"fields.password"`
+	Password2 string `validate:"EqualTo=Password(errors.passwords_differ)"`
+	Age       int    `validate:"Range=18,99"`
+}
+
+func TestValidationMessages(t *testing.T) {
+	config = &Config{DefaultLocale: "en"}
+	catalogs = map[string]map[string]message{"en": {}, "ru": {}}
+	defer func() { catalogs = map[string]map[string]message{} }()
+	addMessages(catalogs["ru"], "", map[string]interface{}{
+		"fields": map[string]interface{}{"email": "Эл. почта", "password": "Пароль"},
+		"validation": map[string]interface{}{
+			"required":   "Поле «{field}» обязательно",
+			"min_length": "{field}: минимум {param} символов",
+		},
+		"errors": map[string]interface{}{"passwords_differ": "Пароли не совпадают"},
+	})
+	form := &testSignup{Password: "short", Password2: "other", Age: 5}
+	tests := []struct {
+		locale string
+		want   map[string]string
+	}{
+		{"ru", map[string]string{
+			"Email":     "Поле «Эл. почта» обязательно",
+			"Password":  "Пароль: минимум 8 символов",
+			"Password2": "Пароли не совпадают",
+			// Built-in default
+			"Age": "Age must be between 18 and 99",
+		}},
+		{"en", map[string]string{
+			"Email":     "Email is required",
+			"Password":  "Password must be at least 8 characters long",
+			"Password2": "Password2 must match Password",
+			"Age":       "Age must be between 18 and 99",
+		}},
+	}
+	for _, test := range tests {
+		errs := (&Controller{Locale: test.locale}).Validate(form)
+		for field, want := range test.want {
+			if msg := errs.First(field); msg != want {
+				t.Errorf("%s: %s = %q, want %q", test.locale, field, msg, want)
+			}
+		}
+	}
+	if key := Validate(form)["Password"][0].Key; key != "validation.min_length" {
+		t.Errorf("Key = %q, want validation.min_length", key)
+	}
+	for in, out := range map[string]string{"MinLength": "min_length",
+		"URL": "url", "EqualTo": "equal_to", "HTTPCode": "http_code"} {
+		if res := snakeCase(in); res != out {
+			t.Errorf("snakeCase(%q) = %q, want %q", in, res, out)
+		}
+	}
+}
+
+type testNode struct {
+	Name string `validate:"Required"`
+	Next *testNode
+}
+
+type testBadRegex struct {
+	Code string `validate:"Regex=\"[a-\""`
+}
+
+type testBadEqualTo struct {
+	Password2 string `validate:"EqualTo=Pasword"`
+}
+
+type testUnexportedEqualTo struct {
+	password  string
+	Password2 string `validate:"EqualTo=password"`
+}
+
+type testBadRule struct {
+	Name string `validate:"Requried"`
+}
+
+type testBadRuleController struct{ *Controller }
+
+func (c *testBadRuleController) SavePOST(form *struct{ Items []testBadRule }) {}
+
+func TestValidateInvalidRules(t *testing.T) {
+	for _, v := range []interface{}{&testBadRegex{Code: "a"},
+		&testBadEqualTo{Password2: "a"}, &testUnexportedEqualTo{Password2: "a"},
+		&testBadRule{}} {
+		if _, err := validate(v, ""); err == nil {
+			t.Errorf("validate(%T) returned no error", v)
+		}
+		// Invalid rules are errors of their fields
+		if errs := Validate(v); len(errs) != 1 {
+			t.Errorf("Validate(%T) = %v, want 1 error", v, errs)
+		}
+	}
+	if err := checkFormRules(reflect.TypeOf(&testBadRuleController{})); err == nil {
+		t.Error("checkFormRules() returned no error for a nested form")
+	}
+	if err := checkFormRules(reflect.TypeOf(&modelStateTest{})); err != nil {
+		t.Errorf("checkFormRules() = %v", err)
+	}
+	// Cyclic structs are validated once
+	n := &testNode{}
+	n.Next = n
+	if errs := Validate(n); len(errs) != 1 || len(errs["Name"]) != 1 {
+		t.Errorf("Validate() = %v for a cyclic struct", errs)
+	}
+}
+
+func TestBindForm(t *testing.T) {
+	type form struct {
+		Agree, Subscribe, Admin bool
+		Billing                 *testAddress
+		Shipping                *testAddress
+	}
+	c := &Controller{Form: map[string]string{"agree": "on", "subscribe": "1",
+		"admin": "no", "billing.city": "Paris"}}
+	var f form
+	c.bindForm(reflect.ValueOf(&f).Elem(), "")
+	if !f.Agree || !f.Subscribe || f.Admin {
+		t.Errorf("bools = %v %v %v, want true true false", f.Agree, f.Subscribe, f.Admin)
+	}
+	if f.Billing == nil || f.Billing.City != "Paris" || f.Shipping != nil {
+		t.Errorf("Billing = %v, Shipping = %v", f.Billing, f.Shipping)
+	}
+}